@@ -0,0 +1,166 @@
+package dga
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/pterm/pterm"
+)
+
+// pathGuard limits the secrets a run can read. A nil guard allows everything.
+// A path has to be allowed by every list, so the allowedPaths input and the policy file can only narrow each other.
+type pathGuard struct {
+	lists []pathList
+}
+
+// pathList is a set of secret path patterns, and where they came from for denial messages.
+type pathList struct {
+	source   string
+	patterns []string
+}
+
+// restrict returns a guard that also requires a path to match one of the patterns.
+func (g *pathGuard) restrict(source string, patterns []string) *pathGuard {
+	restricted := &pathGuard{}
+	if g != nil {
+		restricted.lists = append(restricted.lists, g.lists...)
+	}
+	restricted.lists = append(restricted.lists, pathList{source: source, patterns: patterns})
+	return restricted
+}
+
+// parsePathList splits the allowedPaths input, which has one pattern per line or is comma separated.
+func parsePathList(s string) []string {
+	var patterns []string
+	for _, field := range strings.FieldsFunc(s, func(r rune) bool { return r == '\n' || r == ',' }) {
+		if field = strings.TrimSpace(field); field != "" {
+			patterns = append(patterns, field)
+		}
+	}
+	return patterns
+}
+
+// validatePathPatterns checks each pattern is a usable secret path pattern.
+func validatePathPatterns(source string, patterns []string) []error {
+	var errs []error
+	for _, pattern := range patterns {
+		if normalizePath(pattern) == "" {
+			errs = append(errs, fmt.Errorf("%s can't have an empty path", source))
+		} else if _, err := path.Match(secretPathPattern(pattern), ""); err != nil {
+			errs = append(errs, fmt.Errorf("%s %q: %w", source, pattern, err))
+		}
+	}
+	return errs
+}
+
+// matchPath reports whether the secret path matches the pattern, or is under a path that does.
+// Segments are split on colons, so * never matches across a colon and "ci:team-a:*" doesn't match "ci:team-ab".
+func matchPath(pattern, secretPath string) bool {
+	pattern = secretPathPattern(pattern)
+	segments := strings.Split(normalizePath(secretPath), ":")
+	for i := len(segments); i > 0; i-- {
+		if ok, _ := path.Match(pattern, strings.Join(segments[:i], "/")); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// matchPrefix reports whether a secret under the prefix could match the pattern, so "ci:team-a" can be searched with "ci:team-a:*".
+// Only the segments both have are compared: the prefix is under a shorter pattern, and a longer one may match some of its secrets.
+func matchPrefix(pattern, prefix string) bool {
+	patternSegments := strings.Split(secretPathPattern(pattern), "/")
+	segments := strings.Split(normalizePath(prefix), ":")
+	for i := 0; i < min(len(patternSegments), len(segments)); i++ {
+		if ok, _ := path.Match(patternSegments[i], segments[i]); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// denied returns the first list that doesn't allow the secret path.
+func (g *pathGuard) denied(secretPath string) (pathList, bool) {
+	return g.deniedBy(matchPath, secretPath)
+}
+
+// deniedBy returns the first list with no pattern that matches the path.
+func (g *pathGuard) deniedBy(match func(pattern, secretPath string) bool, secretPath string) (pathList, bool) {
+	if g == nil {
+		return pathList{}, false
+	}
+	for _, list := range g.lists {
+		allowed := false
+		for _, pattern := range list.patterns {
+			if match(pattern, secretPath) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return list, true
+		}
+	}
+	return pathList{}, false
+}
+
+// allows reports whether every list allows the secret path.
+func (g *pathGuard) allows(secretPath string) bool {
+	_, denied := g.denied(secretPath)
+	return !denied
+}
+
+// check returns an error wrapping ErrNotAllowed when the secret path can't be read, logging the decision.
+func (g *pathGuard) check(secretPath string) error {
+	list, denied := g.denied(secretPath)
+	if !denied {
+		return nil
+	}
+	pterm.Warning.Printfln("policy: denied %q, it doesn't match %s", secretPath, list.source)
+	return fmt.Errorf("%q doesn't match any of %s (%s): %w", secretPath, list.source, strings.Join(list.patterns, ", "), ErrNotAllowed)
+}
+
+// checkPrefix returns an error wrapping ErrNotAllowed when no secret under the prefix can be read.
+// Each secret the search finds is still checked when it's read.
+func (g *pathGuard) checkPrefix(prefix string) error {
+	list, denied := g.deniedBy(matchPrefix, prefix)
+	if !denied {
+		return nil
+	}
+	pterm.Warning.Printfln("policy: denied prefix %q, nothing under it matches %s", prefix, list.source)
+	return fmt.Errorf("nothing under %q matches any of %s (%s): %w", prefix, list.source, strings.Join(list.patterns, ", "), ErrNotAllowed)
+}
+
+// checkItems checks every path the items read before anything is requested from DSV.
+func (g *pathGuard) checkItems(items []SecretToRetrieve) error {
+	if g == nil {
+		return nil
+	}
+	var errs []error
+	for _, item := range items {
+		if item.Type == TypeCompose {
+			continue
+		}
+		if item.Type == TypePrefix {
+			if err := g.checkPrefix(item.SecretPath); err != nil {
+				errs = append(errs, err)
+			}
+			continue
+		}
+		for _, candidate := range item.candidates() {
+			if err := g.check(candidate.SecretPath); err != nil {
+				errs = append(errs, err)
+			} else {
+				pterm.Info.Printfln("policy: allowed %q", candidate.SecretPath)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// secretPathPattern converts a secret path pattern to slashes, so path.Match treats each segment separately.
+func secretPathPattern(pattern string) string {
+	return strings.ReplaceAll(normalizePath(pattern), ":", "/")
+}
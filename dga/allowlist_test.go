@@ -0,0 +1,117 @@
+package dga
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/matryer/is"
+	"github.com/pterm/pterm"
+)
+
+func TestMatchPath(t *testing.T) {
+	cases := []struct {
+		pattern string
+		path    string
+		want    bool
+	}{
+		{pattern: "ci:public", path: "ci:public", want: true},
+		{pattern: "ci:public", path: "ci/public/db", want: true},
+		{pattern: "ci:public", path: "ci:publicity"},
+		{pattern: "ci:public", path: "ci"},
+		{pattern: "ci:team-a:*", path: "ci:team-a:db", want: true},
+		{pattern: "ci:team-a:*", path: "ci:team-a:db:primary", want: true},
+		{pattern: "ci:team-a:*", path: "ci:team-ab"},
+		{pattern: "ci:team-a:*", path: "ci:team-ab:db"},
+		{pattern: "ci:team-a*", path: "ci:team-ab:db", want: true},
+		{pattern: "shared:*:readonly", path: "shared:db:readonly:password", want: true},
+		{pattern: "shared:*:readonly", path: "shared:db:admin"},
+		{pattern: "shared:*:readonly", path: "shared:a:b:readonly"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.pattern+" "+tc.path, func(t *testing.T) {
+			is := is.New(t)
+			is.Equal(matchPath(tc.pattern, tc.path), tc.want) // Path should only match within colon delimited segments.
+		})
+	}
+}
+
+func TestMatchPrefix(t *testing.T) {
+	cases := []struct {
+		pattern string
+		prefix  string
+		want    bool
+	}{
+		{pattern: "ci:team-a:*", prefix: "ci:team-a", want: true},
+		{pattern: "ci:team-a:*", prefix: "ci:team-a:db", want: true},
+		{pattern: "ci:team-a:*", prefix: "ci", want: true},
+		{pattern: "ci:team-a:*", prefix: "ci:team-ab"},
+		{pattern: "ci:team-a:*", prefix: "ci:team-b"},
+		{pattern: "shared", prefix: "shared:db", want: true},
+		{pattern: "shared:*:readonly", prefix: "shared:db", want: true},
+		{pattern: "shared:*:readonly", prefix: "shared:db:admin"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.pattern+" "+tc.prefix, func(t *testing.T) {
+			is := is.New(t)
+			is.Equal(matchPrefix(tc.pattern, tc.prefix), tc.want) // Prefix should match when a secret under it could.
+		})
+	}
+}
+
+func TestPathGuard(t *testing.T) {
+	pterm.DisableOutput()
+	is := is.New(t)
+
+	var trusted *pathGuard
+	is.True(trusted.allows("prod:db")) // No guard should allow everything.
+
+	guard := trusted.restrict("DSV_ALLOWED_PATHS", []string{"ci:team-a:*", "shared"})
+	is.True(guard.allows("ci:team-a:db"))  // Path matching the list should be allowed.
+	is.True(!guard.allows("ci:team-b:db")) // Path not matching the list should be denied.
+
+	narrowed := guard.restrict("allowList in policy.json", []string{"shared"})
+	is.True(narrowed.allows("shared:token"))  // Path matching both lists should be allowed.
+	is.True(!narrowed.allows("ci:team-a:db")) // Path has to match every list.
+	is.True(guard.allows("ci:team-a:db"))     // Restricting shouldn't change the original guard.
+
+	err := narrowed.check("ci:team-a:db")
+	is.True(errors.Is(err, ErrNotAllowed))                             // Denied paths should wrap ErrNotAllowed.
+	is.True(strings.Contains(err.Error(), "allowList in policy.json")) // Denial should say which list refused it.
+
+	err = guard.checkItems([]SecretToRetrieve{
+		{SecretPath: "ci:team-a:db", SecretKey: "password", Fallback: []SecretCandidate{{SecretPath: "prod:db"}}},
+		{Type: TypeCompose, OutputVariable: "DSN", Template: "x"},
+	})
+	is.True(errors.Is(err, ErrNotAllowed)) // Fallback paths should be checked as well.
+
+	is.NoErr(guard.checkItems([]SecretToRetrieve{{Type: TypePrefix, SecretPath: "ci:team-a"}}))                          // A prefix should be allowed by a pattern for the secrets under it.
+	is.True(errors.Is(guard.checkItems([]SecretToRetrieve{{Type: TypePrefix, SecretPath: "ci:team-b"}}), ErrNotAllowed)) // A prefix nothing under can match should be denied.
+
+	_, err = DSVGetSecret(&recorder{}, "https://example.com/v1", "token", SecretToRetrieve{SecretPath: "prod:db"}, &Config{guard: guard})
+	is.True(errors.Is(err, ErrNotAllowed)) // Reads outside the allow list should be refused without a request.
+}
+
+func TestApplyPolicyAllowedPaths(t *testing.T) {
+	pterm.DisableOutput()
+	items := []SecretToRetrieve{{SecretPath: "ci:team-ab:db", SecretKey: "password", OutputVariable: "PASSWORD"}}
+	cases := []struct {
+		name    string
+		allowed string
+		wantErr bool
+	}{
+		{name: "not set", allowed: ""},
+		{name: "allowed", allowed: "ci:team-a:*, ci:team-ab"},
+		{name: "one per line", allowed: "ci:team-a:*\nci:team-ab:*\n"},
+		{name: "denied", allowed: "ci:team-a:*", wantErr: true},
+		{name: "invalid pattern", allowed: "ci:[", wantErr: true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			is := is.New(t)
+			cfg := &Config{AllowedPathsEnv: tc.allowed}
+			err := applyPolicy(nil, cfg, items)
+			is.Equal(err != nil, tc.wantErr) // Items should be checked against DSV_ALLOWED_PATHS without calling DSV.
+		})
+	}
+}
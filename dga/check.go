@@ -0,0 +1,139 @@
+package dga
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pterm/pterm"
+)
+
+// Results of checking an item in a dry run.
+const (
+	checkPass = "pass"
+	checkFail = "fail"
+)
+
+// forgetValue drops the value of a result once a dry run has checked it, so it isn't kept while the other items are checked.
+func forgetValue(res itemResult) itemResult {
+	res.Value = ""
+	return res
+}
+
+// checkOf is pass when the item would be exported or skipped in a real run, or fail when it would stop the run.
+func checkOf(res itemResult) string {
+	if res.Status == StatusFailed {
+		return checkFail
+	}
+	return checkPass
+}
+
+// checkCompose checks each compose item in a dry run, without rendering its template, as the values it needs aren't kept.
+// A compose item passes when every item it references does, and one that references a skipped or failed item is treated as missing.
+func checkCompose(items []SecretToRetrieve, results []itemResult, policy string) []itemResult {
+	order, err := composeOrder(items)
+	if err != nil {
+		// Already checked by validateItems, so this only happens if the two get out of step.
+		return append(results, itemResult{Status: StatusFailed, Err: err})
+	}
+
+	skipped := make(map[string]bool)
+	for _, res := range results {
+		if res.Status == StatusSkipped || res.Status == StatusFailed {
+			skipped[strings.ToUpper(res.Item.OutputVariable)] = true
+		}
+	}
+	for _, item := range order {
+		res := itemResult{Item: item, Status: StatusComposed}
+		refs, err := composeRefs(item)
+		if err != nil {
+			res = itemResult{Item: item, Status: StatusFailed, Err: fmt.Errorf("%q: invalid template: %w", item.OutputVariable, err)}
+		}
+		for _, ref := range refs {
+			if skipped[ref] {
+				res = itemResult{Item: item, Status: StatusFailed, Err: fmt.Errorf("%q: ref %q has no value: %w", item.OutputVariable, ref, ErrNotFound)}
+				break
+			}
+		}
+		res = applyMissingPolicy(res, policy)
+		if res.Status != StatusComposed && res.Status != StatusDefault {
+			skipped[strings.ToUpper(item.OutputVariable)] = true
+		}
+		results = append(results, forgetValue(res))
+	}
+	return results
+}
+
+// checkReason is why an item failed a dry run, or what a person should know about one that passed.
+func checkReason(res itemResult) string {
+	if res.Status == StatusFailed {
+		return failureReason(res.Err)
+	}
+	warnings := resultWarnings(res)
+	if res.Status == StatusComposed {
+		warnings = append(warnings, "template not rendered")
+	}
+	return strings.Join(warnings, "; ")
+}
+
+// printCheckReport renders a table of whether each item passed a dry run.
+func printCheckReport(results []itemResult) {
+	data := [][]string{{"Secret Path", "Secret Key", "Output Variable", "Used", "Version", "Check", "Reason"}}
+	for _, res := range results {
+		version := ""
+		if res.Metadata != nil {
+			version = res.Metadata.Version
+		}
+		used := ""
+		if res.Used.SecretPath != "" {
+			used = res.Used.String()
+		}
+		data = append(data, []string{
+			res.Item.SecretPath,
+			res.Item.SecretKey,
+			strings.ToUpper(res.Item.OutputVariable),
+			used,
+			version,
+			checkOf(res),
+			checkReason(res),
+		})
+	}
+	if err := pterm.DefaultTable.WithHasHeader().WithData(data).Render(); err != nil {
+		pterm.Warning.Printfln("unable to render report: %v", err)
+	}
+}
+
+// checkSummary is a Markdown table of whether each item passed a dry run.
+func checkSummary(results []itemResult) string {
+	var sb strings.Builder
+	sb.WriteString("### DSV Access Check\n\n")
+
+	failed := 0
+	for _, res := range results {
+		if res.Status == StatusFailed {
+			failed++
+		}
+	}
+	if failed > 0 {
+		sb.WriteString(fmt.Sprintf(":x: **%d of %d items failed.** This was a dry run, nothing was exported.\n\n", failed, len(results)))
+	} else {
+		sb.WriteString(fmt.Sprintf(":white_check_mark: %d items passed. This was a dry run, nothing was exported.\n\n", len(results)))
+	}
+
+	sb.WriteString("| Path | Key | Output | Check | Reason |\n")
+	sb.WriteString("| --- | --- | --- | --- | --- |\n")
+	for _, res := range results {
+		check := ":white_check_mark: " + checkPass
+		if res.Status == StatusFailed {
+			check = ":x: " + checkFail
+		}
+		sb.WriteString(fmt.Sprintf("| %s | %s | %s | %s | %s |\n",
+			markdownCode(res.Item.SecretPath),
+			markdownCode(res.Item.SecretKey),
+			markdownCode(strings.ToUpper(res.Item.OutputVariable)),
+			check,
+			strings.NewReplacer("|", "\\|", "\n", " ").Replace(checkReason(res)),
+		))
+	}
+	sb.WriteString("\n")
+	return sb.String()
+}
@@ -0,0 +1,45 @@
+package dga
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/matryer/is"
+	"github.com/pterm/pterm"
+)
+
+func TestCheckCompose(t *testing.T) {
+	pterm.DisableOutput()
+	def := "fallback"
+	items := []SecretToRetrieve{
+		{SecretPath: "ci:db", SecretKey: "user", OutputVariable: "USER"},
+		{SecretPath: "ci:db", SecretKey: "password", OutputVariable: "PASSWORD"},
+		{Type: TypeCompose, OutputVariable: "LOGIN", Template: `{{ ref "USER" }}`},
+		{Type: TypeCompose, OutputVariable: "DSN", Template: `{{ ref "LOGIN" }}:{{ ref "PASSWORD" }}`},
+		{Type: TypeCompose, OutputVariable: "WITH_DEFAULT", Template: `{{ ref "PASSWORD" }}`, Default: &def},
+	}
+	results := []itemResult{
+		{Item: items[0], Status: StatusRetrieved},
+		{Item: items[1], Status: StatusFailed, Err: fmt.Errorf("%w", ErrNotFound)},
+	}
+
+	got := checkCompose(items, results, OnMissingFail)
+	is := is.New(t)
+	is.Equal(len(got), 5)                                  // Every compose item should be checked.
+	is.Equal(got[2].Status, StatusComposed)                // A compose item whose refs pass should pass.
+	is.Equal(checkReason(got[2]), "template not rendered") // The template isn't rendered without the values.
+	is.Equal(got[3].Status, StatusFailed)                  // A compose item referencing a failed item should fail.
+	is.True(errors.Is(got[3].Err, ErrNotFound))            // It should fail as missing.
+	is.Equal(got[4].Status, StatusDefault)                 // The default should apply, the same as a real run.
+	is.Equal(got[4].Value, "")                             // Values shouldn't be kept, not even a default.
+}
+
+func TestForgetValue(t *testing.T) {
+	is := is.New(t)
+	res := forgetValue(itemResult{Status: StatusRetrieved, Value: "hunter2", Metadata: &SecretMetadata{Version: "2"}})
+	is.Equal(res.Value, "")                                        // Value should be dropped.
+	is.Equal(res.Metadata.Version, "2")                            // Metadata should be kept for the report.
+	is.Equal(checkOf(res), checkPass)                              // A retrieved item passes.
+	is.Equal(checkOf(itemResult{Status: StatusFailed}), checkFail) // A failed item fails.
+}
@@ -0,0 +1,298 @@
+// Package cli is the command line of dsv-github-action, so the same binary that runs as a GitHub Action can be used on a laptop or in another CI system.
+// Every command reads its configuration from the environment, the same as the action, and flags generated from the tags of dga.Config take precedence over it.
+package cli
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/DelineaXPM/dsv-github-action/dga"
+	env "github.com/caarlos0/env/v10"
+	"github.com/pterm/pterm"
+)
+
+// Name is the name of the binary in usage messages.
+const Name = "dsv-github-action"
+
+// App runs the commands of the binary.
+type App struct {
+	Version string
+	Commit  string
+	Date    string
+	// Stdout receives the output of a command, such as a secret value or the schema. It's os.Stdout when nil.
+	Stdout io.Writer
+	// Stderr receives usage messages. It's os.Stderr when nil.
+	Stderr io.Writer
+	// Environ is the environment flags are applied over. It's the environment of the process when nil.
+	Environ map[string]string
+	// NewRunner creates the Runner for a command's configuration.
+	// When it and Environ are nil, run and doctor call dga.Run and dga.RunDoctor, and the other commands use dga.NewRunner.
+	NewRunner func(cfg dga.Config) *dga.Runner
+}
+
+// command is a subcommand and what it needs.
+type command struct {
+	name    string
+	args    string // args describes the arguments after the flags in usage messages.
+	summary string
+	config  bool // config commands have a flag for each field of dga.Config.
+	run     func(a *App, cfg dga.Config, args []string) error
+}
+
+// commands are the subcommands, in the order they're listed by help.
+//
+//nolint:gochecknoglobals // read only list.
+var commands = []command{
+	{name: "run", summary: "Retrieve the secrets and export them for later steps, the default when no command is given.", config: true, run: (*App).run},
+	{name: "get", args: "<path>#<key>", summary: "Retrieve one key of a secret and print its value.", config: true, run: (*App).get},
+	{name: "validate", summary: "Check the inputs and retrieve configuration without contacting DSV.", config: true, run: (*App).validate},
+	{name: "doctor", summary: "Diagnose connectivity and configuration problems.", config: true, run: (*App).doctor},
+	{name: "schema", summary: "Print the JSON schema of the retrieve configuration.", run: (*App).schema},
+	{name: "version", summary: "Print the version.", run: (*App).version},
+}
+
+// stdout returns the output, or os.Stdout.
+func (a *App) stdout() io.Writer {
+	if a.Stdout == nil {
+		return os.Stdout
+	}
+	return a.Stdout
+}
+
+// stderr returns the output for usage messages, or os.Stderr.
+func (a *App) stderr() io.Writer {
+	if a.Stderr == nil {
+		return os.Stderr
+	}
+	return a.Stderr
+}
+
+// environ returns a copy of the environment, so flags can be applied to it.
+func (a *App) environ() map[string]string {
+	if a.Environ == nil {
+		return env.ToMap(os.Environ())
+	}
+	environ := make(map[string]string, len(a.Environ))
+	for key, val := range a.Environ {
+		environ[key] = val
+	}
+	return environ
+}
+
+// processEnviron reports whether commands run against the environment of the process, as dga.Run and dga.RunDoctor do.
+func (a *App) processEnviron() bool {
+	return a.Environ == nil && a.NewRunner == nil
+}
+
+// runner returns the Runner for cfg.
+func (a *App) runner(cfg dga.Config) *dga.Runner {
+	if a.NewRunner == nil {
+		return dga.NewRunner(cfg)
+	}
+	return a.NewRunner(cfg)
+}
+
+// Run runs the command named by the first argument, or run when the first argument is a flag or there are none.
+// Asking for help isn't an error, and a usage error is a dga.KindConfig error.
+func (a *App) Run(args []string) error {
+	name := "run"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+	if name == "help" {
+		a.usage()
+		return nil
+	}
+	cmd, ok := findCommand(name)
+	if !ok {
+		a.usage()
+		return &dga.Error{Kind: dga.KindConfig, Hint: "run " + Name + " help to list the commands", Err: fmt.Errorf("unknown command %q", name)}
+	}
+
+	environ := a.environ()
+	flags := flag.NewFlagSet(Name+" "+cmd.name, flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	var fields []configFlag
+	if cmd.config {
+		fields = configFlags(flags, environ)
+	}
+	if err := flags.Parse(args); err != nil {
+		a.commandUsage(cmd, fields)
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return &dga.Error{Kind: dga.KindConfig, Err: err}
+	}
+	if a.processEnviron() {
+		// dga.Run and dga.RunDoctor read the environment of the process, so the flags are set in it too.
+		var err error
+		flags.Visit(func(f *flag.Flag) {
+			if field, ok := f.Value.(*configFlag); ok && err == nil {
+				err = os.Setenv(field.variable, environ[field.variable])
+			}
+		})
+		if err != nil {
+			return &dga.Error{Kind: dga.KindConfig, Err: err}
+		}
+	}
+	if cmd.args == "" && flags.NArg() > 0 {
+		a.commandUsage(cmd, fields)
+		return &dga.Error{Kind: dga.KindConfig, Err: fmt.Errorf("%s doesn't take arguments, got %q", cmd.name, flags.Args())}
+	}
+	if !cmd.config {
+		return cmd.run(a, dga.Config{}, flags.Args())
+	}
+
+	cfg, err := dga.LoadConfig(environ)
+	if err != nil && (cmd.name == "run" || cmd.name == "get" || !onlyMissing(err)) {
+		return err
+	}
+	if err != nil {
+		pterm.Debug.Printfln("ignoring inputs %s doesn't need: %v", cmd.name, err)
+	}
+	return cmd.run(a, cfg, flags.Args())
+}
+
+// findCommand returns the command with the name.
+func findCommand(name string) (command, bool) {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd, true
+		}
+	}
+	return command{}, false
+}
+
+// onlyMissing reports whether every error parsing the environment is a variable that isn't set, which validate and doctor can do without.
+func onlyMissing(err error) bool {
+	var aggregate env.AggregateError
+	if !errors.As(err, &aggregate) {
+		return false
+	}
+	for _, e := range aggregate.Errors {
+		var notSet env.EnvVarIsNotSetError
+		var empty env.EmptyEnvVarError
+		if !errors.As(e, &notSet) && !errors.As(e, &empty) {
+			return false
+		}
+	}
+	return true
+}
+
+// configFlag is a flag for a field of dga.Config, which sets the variable the field is read from.
+type configFlag struct {
+	name     string
+	variable string
+	help     string
+	def      string
+	required bool
+	isBool   bool
+	environ  map[string]string
+}
+
+// String is the value of the flag, which flag only uses to show defaults.
+func (f *configFlag) String() string {
+	if f == nil {
+		return ""
+	}
+	return f.def
+}
+
+// Set sets the variable, so the flag takes precedence over the environment.
+func (f *configFlag) Set(val string) error {
+	f.environ[f.variable] = val
+	return nil
+}
+
+// IsBoolFlag lets a bool flag be set without a value, such as --dry-run.
+func (f *configFlag) IsBoolFlag() bool {
+	return f.isBool
+}
+
+// configFlags defines a flag for each field of dga.Config with a help tag, which sets the field's variable in environ.
+func configFlags(flags *flag.FlagSet, environ map[string]string) []configFlag {
+	var fields []configFlag
+	typ := reflect.TypeOf(dga.Config{})
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		help := field.Tag.Get("help")
+		if help == "" {
+			continue
+		}
+		variable, opts, _ := strings.Cut(field.Tag.Get("env"), ",")
+		f := &configFlag{
+			name:     field.Tag.Get("flag"),
+			variable: variable,
+			help:     help,
+			def:      field.Tag.Get("envDefault"),
+			required: strings.Contains(opts, "required"),
+			isBool:   field.Type.Kind() == reflect.Bool,
+			environ:  environ,
+		}
+		if f.name == "" {
+			f.name = flagName(variable)
+		}
+		flags.Var(f, f.name, help)
+		fields = append(fields, *f)
+	}
+	return fields
+}
+
+// flagName is the flag for an environment variable, such as retrieve-file for DSV_RETRIEVE_FILE.
+func flagName(variable string) string {
+	return strings.ReplaceAll(strings.ToLower(strings.TrimPrefix(variable, "DSV_")), "_", "-")
+}
+
+// usage lists the commands.
+func (a *App) usage() {
+	w := tabwriter.NewWriter(a.stderr(), 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "Usage: %s [command] [flags]\n\nCommands:\n", Name)
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %s\t%s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintf(w, "\nRun %s <command> --help for the flags of a command.\n", Name)
+	w.Flush()
+}
+
+// commandUsage describes a command and each of its flags, with the variable it overrides.
+func (a *App) commandUsage(cmd command, fields []configFlag) {
+	w := tabwriter.NewWriter(a.stderr(), 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "Usage: %s %s", Name, cmd.name)
+	if len(fields) > 0 {
+		fmt.Fprint(w, " [flags]")
+	}
+	if cmd.args != "" {
+		fmt.Fprint(w, " "+cmd.args)
+	}
+	fmt.Fprintf(w, "\n\n%s\n", cmd.summary)
+	if len(fields) == 0 {
+		w.Flush()
+		return
+	}
+
+	sort.Slice(fields, func(i, j int) bool { return fields[i].name < fields[j].name })
+	fmt.Fprint(w, "\nFlags take precedence over the environment variable in brackets.\n\nFlags:\n")
+	for _, f := range fields {
+		arg := " value"
+		if f.isBool {
+			arg = ""
+		}
+		var notes []string
+		notes = append(notes, f.variable)
+		if f.required {
+			notes = append(notes, "required")
+		}
+		if f.def != "" {
+			notes = append(notes, "default "+f.def)
+		}
+		fmt.Fprintf(w, "  --%s%s\t%s [%s]\n", f.name, arg, f.help, strings.Join(notes, ", "))
+	}
+	w.Flush()
+}
@@ -0,0 +1,198 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/DelineaXPM/dsv-github-action/dga"
+	"github.com/DelineaXPM/dsv-github-action/dga/dsvtest"
+	"github.com/matryer/is"
+	"github.com/pterm/pterm"
+)
+
+func TestApp(t *testing.T) {
+	fake := dsvtest.New("client-id", "client-secret")
+	fake.AddSecret("ci:db", map[string]any{"password": "hunter2"})
+	server := fake.Start()
+	defer server.Close()
+	plain := httptest.NewServer(fake)
+	defer plain.Close()
+	credentials := map[string]string{
+		"DSV_DOMAIN":        server.Listener.Addr().String(),
+		"DSV_CLIENT_ID":     "client-id",
+		"DSV_CLIENT_SECRET": "client-secret",
+		// The fake isn't in one of DSV's regions.
+		"DSV_ALLOW_CUSTOM_DOMAIN": "true",
+	}
+
+	cases := []struct {
+		name       string
+		args       []string
+		env        map[string]string // env is set over the credentials.
+		noCreds    bool              // noCreds leaves out the credentials.
+		wantOut    string            // wantOut is the whole output, or part of it for usage.
+		wantStderr []string
+		wantKind   dga.Kind // wantKind is the kind of the error, which isn't expected when 0.
+	}{
+		{name: "version", args: []string{"version"}, wantOut: "version: 1.2.3\ncommit: abc\nbuilt: today\n"},
+		{name: "help lists commands", args: []string{"help"}, wantStderr: []string{"run ", "get ", "validate ", "doctor ", "schema ", "version "}},
+		{
+			name: "help is generated from the config", args: []string{"get", "--help"},
+			wantStderr: []string{"get [flags] <path>#<key>", "--client-id value", "[DSV_CLIENT_ID, required]", "--on-missing value", "default fail", "--dry-run ", "--debug ", "[RUNNER_DEBUG]"},
+		},
+		{name: "unknown command", args: []string{"fetch"}, wantKind: dga.KindConfig, wantStderr: []string{"Commands:"}},
+		{name: "unknown flag", args: []string{"validate", "--domian", "x"}, wantKind: dga.KindConfig},
+		{name: "arguments to a command without any", args: []string{"validate", "extra"}, wantKind: dga.KindConfig},
+		{name: "get", args: []string{"get", "ci:db#password"}, wantOut: "hunter2\n"},
+		{
+			name: "flags take precedence", args: []string{"get", "--client-secret", "client-secret", "ci:db#password"},
+			env: map[string]string{"DSV_CLIENT_SECRET": "wrong"}, wantOut: "hunter2\n",
+		},
+		{name: "get from flags only", args: []string{"get", "--domain", server.Listener.Addr().String(), "--allow-custom-domain", "--client-id", "client-id", "--client-secret", "client-secret", "ci:db#password"}, noCreds: true, wantOut: "hunter2\n"},
+		{name: "get missing key", args: []string{"get", "ci:db#user"}, wantKind: dga.KindKeyMissing},
+		{name: "get without key", args: []string{"get", "ci:db"}, wantKind: dga.KindConfig},
+		{name: "get without credentials", args: []string{"get", "ci:db#password"}, noCreds: true, wantKind: dga.KindConfig},
+		{name: "get with wrong credentials", args: []string{"get", "--client-secret", "wrong", "ci:db#password"}, wantKind: dga.KindAuthentication},
+		{
+			name: "base URL", args: []string{"get", "--base-url", server.URL + "/v1/", "ci:db#password"},
+			env: map[string]string{"DSV_DOMAIN": "example.secretsvaultcloud.com", "DSV_ALLOW_CUSTOM_DOMAIN": "false"}, wantOut: "hunter2\n",
+		},
+		{
+			name: "get with only a base URL", args: []string{"get", "--base-url", server.URL + "/v1", "ci:db#password"},
+			env: map[string]string{"DSV_DOMAIN": "", "DSV_ALLOW_CUSTOM_DOMAIN": "false"}, wantOut: "hunter2\n",
+		},
+		{
+			name: "validate with only a base URL", args: []string{"validate", "--base-url", server.URL + "/v1", "--retrieve", `[{"secretPath":"ci:db","secretKey":"password","outputVariable":"PASSWORD"}]`},
+			env: map[string]string{"DSV_DOMAIN": "", "DSV_ALLOW_CUSTOM_DOMAIN": "false"},
+		},
+		{name: "get without domain or base URL", args: []string{"get", "ci:db#password"}, env: map[string]string{"DSV_DOMAIN": ""}, wantKind: dga.KindConfig},
+		{name: "domain typo", args: []string{"get", "ci:db#password"}, env: map[string]string{"DSV_DOMAIN": "example.secretsvaultcloud.co", "DSV_ALLOW_CUSTOM_DOMAIN": "false"}, wantKind: dga.KindConfig},
+		{name: "plain http", args: []string{"get", "--base-url", plain.URL + "/v1", "ci:db#password"}, wantKind: dga.KindConfig},
+		{name: "plain http with insecure", args: []string{"get", "--base-url", plain.URL + "/v1", "--insecure", "ci:db#password"}, wantOut: "hunter2\n"},
+		{name: "validate without credentials", args: []string{"validate", "--retrieve", `[{"secretPath":"ci:db","secretKey":"password","outputVariable":"PASSWORD"}]`}, noCreds: true},
+		{name: "validate invalid retrieve", args: []string{"validate", "--retrieve", `{"secretPath":"ci:db"}`}, noCreds: true, wantKind: dga.KindConfig},
+		{name: "validate invalid flag value", args: []string{"validate", "--max-ref-depth", "deep"}, noCreds: true, wantKind: dga.KindConfig},
+		{name: "validate allowed paths", args: []string{"validate", "--allowed-paths", "ci:app:*", "--retrieve", `[{"secretPath":"ci:db","secretKey":"password","outputVariable":"PASSWORD"}]`}, wantKind: dga.KindForbidden},
+		{name: "run is the default", args: []string{"--dry-run", "--retrieve", `[{"secretPath":"ci:db","secretKey":"password","outputVariable":"PASSWORD"}]`}},
+		{name: "run reports failures", args: []string{"run", "--retrieve", `[{"secretPath":"ci:missing","secretKey":"password","outputVariable":"PASSWORD"}]`}, wantKind: dga.KindNotFound},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			pterm.DisableOutput()
+			is := is.New(t)
+			environ := map[string]string{}
+			if !tc.noCreds {
+				for key, val := range credentials {
+					environ[key] = val
+				}
+			}
+			for key, val := range tc.env {
+				environ[key] = val
+			}
+			var stdout, stderr bytes.Buffer
+			app := &App{
+				Version: "1.2.3", Commit: "abc", Date: "today",
+				Stdout: &stdout, Stderr: &stderr, Environ: environ,
+				NewRunner: func(cfg dga.Config) *dga.Runner {
+					return &dga.Runner{Config: cfg, HTTP: server.Client(), Output: &stderr, LookupEnv: func(string) (string, bool) { return "", false }}
+				},
+			}
+
+			err := app.Run(tc.args)
+			if tc.wantKind == 0 {
+				is.NoErr(err) // Command should succeed.
+			} else {
+				var e *dga.Error
+				is.True(errors.As(err, &e))   // Command should fail with a kind, for the exit code.
+				is.Equal(e.Kind, tc.wantKind) // Command should fail with the expected kind.
+			}
+			if tc.wantOut != "" {
+				is.Equal(stdout.String(), tc.wantOut) // Output should only be what the command prints.
+			}
+			for _, want := range tc.wantStderr {
+				is.True(strings.Contains(stderr.String(), want)) // Usage should describe the command.
+			}
+		})
+	}
+}
+
+func TestSecretsAreNotPrintedOutsideOfCI(t *testing.T) {
+	fake := dsvtest.New("client-id", "client-secret")
+	fake.AddSecret("ci:db", map[string]any{"password": "hunter2"})
+	server := fake.Start()
+	defer server.Close()
+	environ := map[string]string{
+		"DSV_DOMAIN":              server.Listener.Addr().String(),
+		"DSV_CLIENT_ID":           "client-id",
+		"DSV_CLIENT_SECRET":       "client-secret",
+		"DSV_ALLOW_CUSTOM_DOMAIN": "true",
+		"DSV_RETRIEVE":            `[{"secretPath":"ci:db","secretKey":"password","outputVariable":"PASSWORD"}]`,
+		"RUNNER_DEBUG":            "true",
+	}
+
+	cases := []struct {
+		name    string
+		args    []string
+		wantOut string
+	}{
+		{name: "get", args: []string{"get", "ci:db#password"}, wantOut: "hunter2\n"},
+		{name: "run", args: []string{"run"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			is := is.New(t)
+			var stdout, stderr bytes.Buffer
+			// Logs are written too, as that's where a mask would print the value.
+			pterm.EnableOutput()
+			pterm.SetDefaultOutput(&stderr)
+			defer func() {
+				pterm.SetDefaultOutput(os.Stdout)
+				pterm.DisableOutput()
+				pterm.DisableDebugMessages()
+			}()
+			app := &App{
+				Stdout: &stdout, Stderr: &stderr, Environ: environ,
+				NewRunner: func(cfg dga.Config) *dga.Runner {
+					return &dga.Runner{Config: cfg, HTTP: server.Client(), Output: &stderr, LookupEnv: func(string) (string, bool) { return "", false }}
+				},
+			}
+
+			is.NoErr(app.Run(tc.args))            // Command should succeed.
+			is.Equal(stdout.String(), tc.wantOut) // Only get should print the value, and nothing else.
+			for _, secret := range []string{"hunter2", "client-secret", "client-id"} {
+				is.True(!strings.Contains(stderr.String(), secret)) // Outside of CI a mask would only print the secret.
+			}
+		})
+	}
+}
+
+func TestFlagsSetTheProcessEnvironment(t *testing.T) {
+	pterm.DisableOutput()
+	is := is.New(t)
+	t.Setenv("DSV_RETRIEVE", "") // Restores the variable afterwards.
+	retrieve := `[{"secretPath":"ci:db","secretKey":"password","outputVariable":"PASSWORD"}]`
+	app := &App{Stdout: &bytes.Buffer{}, Stderr: &bytes.Buffer{}}
+	is.NoErr(app.Run([]string{"validate", "--retrieve", retrieve})) // Command should succeed.
+	is.Equal(os.Getenv("DSV_RETRIEVE"), retrieve)                   // dga.Run and dga.RunDoctor should see the flag.
+}
+
+func TestSchemaCommand(t *testing.T) {
+	is := is.New(t)
+	var stdout bytes.Buffer
+	app := &App{Stdout: &stdout, Environ: map[string]string{}}
+	is.NoErr(app.Run([]string{"schema"})) // Schema should be printed.
+	var schema map[string]any
+	is.NoErr(json.Unmarshal(stdout.Bytes(), &schema)) // Schema should be valid JSON.
+	is.Equal(schema["type"], "array")                 // Retrieve is a list of items.
+}
+
+func TestFlagName(t *testing.T) {
+	is := is.New(t)
+	is.Equal(flagName("DSV_RETRIEVE_FILE"), "retrieve-file") // DSV_ is dropped and words are joined with a dash.
+	is.Equal(flagName("DSV_DOMAIN"), "domain")               // A single word is kept.
+}
@@ -0,0 +1,66 @@
+package cli
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/DelineaXPM/dsv-github-action/dga"
+	"github.com/pterm/pterm"
+)
+
+// run retrieves and exports the secrets, the same as the action.
+func (a *App) run(cfg dga.Config, _ []string) error {
+	pterm.Info.Printf("version: %s\n"+"commit: %s\n"+"built: %s\n", a.Version, a.Commit, a.Date)
+	run := dga.Run
+	if !a.processEnviron() {
+		run = a.runner(cfg).Run
+	}
+	if err := run(); err != nil {
+		return err
+	}
+	pterm.Success.Println("complete with success")
+	return nil
+}
+
+// get prints the value of one key of a secret, given as path#key.
+// Everything but the value is written to stderr, so the output can be used by a script.
+func (a *App) get(cfg dga.Config, args []string) error {
+	if len(args) != 1 {
+		return &dga.Error{Kind: dga.KindConfig, Hint: "get takes one argument, such as ci:db#password", Err: fmt.Errorf("expected one secret, got %d", len(args))}
+	}
+	path, key, ok := strings.Cut(args[0], "#")
+	if !ok || path == "" || key == "" {
+		return &dga.Error{Kind: dga.KindConfig, Hint: "separate the secret path and key with #, such as ci:db#password", Err: fmt.Errorf("%q has no key", args[0])}
+	}
+	pterm.SetDefaultOutput(a.stderr())
+	r := a.runner(cfg)
+	if r.Output == nil {
+		r.Output = a.stderr()
+	}
+	return r.Get(a.stdout(), path, key)
+}
+
+// validate checks the configuration without contacting DSV.
+func (a *App) validate(cfg dga.Config, _ []string) error {
+	return a.runner(cfg).Validate()
+}
+
+// doctor runs the doctor checks.
+func (a *App) doctor(cfg dga.Config, _ []string) error {
+	if a.processEnviron() {
+		return dga.RunDoctor()
+	}
+	return dga.NewDoctor(a.runner(cfg)).Run()
+}
+
+// schema prints the JSON schema of the retrieve configuration.
+func (a *App) schema(dga.Config, []string) error {
+	_, err := a.stdout().Write(dga.RetrieveSchema())
+	return err
+}
+
+// version prints the version the binary was built from.
+func (a *App) version(dga.Config, []string) error {
+	_, err := fmt.Fprintf(a.stdout(), "version: %s\ncommit: %s\nbuilt: %s\n", a.Version, a.Commit, a.Date)
+	return err
+}
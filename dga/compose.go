@@ -0,0 +1,303 @@
+package dga
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"text/template"
+	"text/template/parse"
+	"time"
+
+	"github.com/pterm/pterm"
+)
+
+// TypeCompose builds its value from a template that references the values of other items.
+const TypeCompose = "compose"
+
+// StatusComposed is a value built from other items by a compose item.
+const StatusComposed ItemStatus = "composed"
+
+// composeFuncs are the helpers available to a compose template, besides ref.
+//
+//nolint:gochecknoglobals // read only map of template helpers.
+var composeFuncs = template.FuncMap{
+	// urlUserinfo escapes a user name or password for use before the @ in a URL.
+	"urlUserinfo": func(s string) string {
+		return strings.TrimSuffix(url.User(s).String(), "@")
+	},
+	// urlPath escapes a single path segment of a URL.
+	"urlPath": url.PathEscape,
+	// urlQuery escapes a URL query parameter.
+	"urlQuery": url.QueryEscape,
+	// json quotes a value as a JSON string.
+	"json": func(s string) (string, error) {
+		b, err := json.Marshal(s)
+		return string(b), err
+	},
+	// shell quotes a value for a POSIX shell.
+	"shell": func(s string) string {
+		return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+	},
+}
+
+// parseCompose parses the template of a compose item, with ref returning values from the map.
+func parseCompose(item SecretToRetrieve, values map[string]string) (*template.Template, error) {
+	funcs := template.FuncMap{
+		"ref": func(name string) (string, error) {
+			val, ok := values[strings.ToUpper(name)]
+			if !ok {
+				return "", fmt.Errorf("ref %q has no value", name)
+			}
+			return val, nil
+		},
+	}
+	for name, fn := range composeFuncs {
+		funcs[name] = fn
+	}
+	return template.New(item.OutputVariable).Option("missingkey=error").Funcs(funcs).Parse(item.Template)
+}
+
+// composeRefs returns every output variable the template of a compose item references with ref.
+// References have to be string literals, so they can all be checked before anything is requested from DSV.
+func composeRefs(item SecretToRetrieve) ([]string, error) {
+	tmpl, err := parseCompose(item, nil)
+	if err != nil {
+		return nil, err
+	}
+	var refs []string
+	var errs []error
+	var walk func(node parse.Node)
+	walk = func(node parse.Node) {
+		switch n := node.(type) {
+		case *parse.ListNode:
+			if n == nil {
+				return
+			}
+			for _, child := range n.Nodes {
+				walk(child)
+			}
+		case *parse.ActionNode:
+			walk(n.Pipe)
+		case *parse.IfNode:
+			walk(n.Pipe)
+			walk(n.List)
+			walk(n.ElseList)
+		case *parse.RangeNode:
+			walk(n.Pipe)
+			walk(n.List)
+			walk(n.ElseList)
+		case *parse.WithNode:
+			walk(n.Pipe)
+			walk(n.List)
+			walk(n.ElseList)
+		case *parse.TemplateNode:
+			walk(n.Pipe)
+		case *parse.PipeNode:
+			if n == nil {
+				return
+			}
+			for _, cmd := range n.Cmds {
+				walk(cmd)
+			}
+		case *parse.CommandNode:
+			if ident, ok := n.Args[0].(*parse.IdentifierNode); ok && ident.Ident == "ref" {
+				if len(n.Args) != 2 {
+					errs = append(errs, fmt.Errorf("ref takes exactly one name"))
+					break
+				}
+				name, ok := n.Args[1].(*parse.StringNode)
+				if !ok {
+					errs = append(errs, fmt.Errorf("ref needs a quoted name, such as ref \"DB_USER\""))
+					break
+				}
+				refs = append(refs, strings.ToUpper(name.Text))
+			}
+			for _, arg := range n.Args {
+				walk(arg)
+			}
+		}
+	}
+	walk(tmpl.Tree.Root)
+	return refs, errors.Join(errs...)
+}
+
+// composeOrder checks every compose item only references items that exist and don't depend on each other in a cycle.
+// It returns the compose items in the order they can be evaluated.
+func composeOrder(items []SecretToRetrieve) ([]SecretToRetrieve, error) {
+	defined := make(map[string]bool)
+	composed := make(map[string]SecretToRetrieve)
+	refs := make(map[string][]string)
+	var errs []error
+	for _, item := range items {
+		switch item.Type {
+		case TypePrefix:
+			continue
+		case TypeCompose:
+			name := strings.ToUpper(item.OutputVariable)
+			composed[name] = item
+			r, err := composeRefs(item)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%q: invalid template: %w", item.OutputVariable, err))
+			}
+			refs[name] = r
+		}
+		defined[strings.ToUpper(item.OutputVariable)] = true
+	}
+	for _, item := range items {
+		if item.Type != TypeCompose {
+			continue
+		}
+		name := strings.ToUpper(item.OutputVariable)
+		for _, ref := range refs[name] {
+			if !defined[ref] {
+				errs = append(errs, fmt.Errorf("%q: ref %q is not the outputVariable of another item", item.OutputVariable, ref))
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	// Depth first search, visiting references before the item that uses them.
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[string]int)
+	var order []SecretToRetrieve
+	var visit func(name string, chain []string) error
+	visit = func(name string, chain []string) error {
+		item, isCompose := composed[name]
+		if !isCompose {
+			return nil
+		}
+		switch state[name] {
+		case visiting:
+			return fmt.Errorf("circular reference: %s", strings.Join(append(chain, name), " -> "))
+		case visited:
+			return nil
+		}
+		state[name] = visiting
+		for _, ref := range refs[name] {
+			if err := visit(ref, append(chain, name)); err != nil {
+				return err
+			}
+		}
+		state[name] = visited
+		order = append(order, item)
+		return nil
+	}
+	for _, item := range items {
+		if item.Type != TypeCompose {
+			continue
+		}
+		if err := visit(strings.ToUpper(item.OutputVariable), nil); err != nil {
+			return nil, err
+		}
+	}
+	return order, nil
+}
+
+// validateComposeItems checks the fields of compose items, which don't read a secret themselves.
+func validateComposeItems(items []SecretToRetrieve) []error {
+	var errs []error
+	for _, item := range items {
+		if item.Type != TypeCompose {
+			if item.Template != "" {
+				errs = append(errs, fmt.Errorf("%q: template can only be used with a compose item", item.SecretPath))
+			}
+			continue
+		}
+		if item.Template == "" {
+			errs = append(errs, fmt.Errorf("%q: a compose item needs a template", item.OutputVariable))
+		}
+		if item.SecretPath != "" || item.SecretKey != "" || item.Version != "" || len(item.Fallback) > 0 {
+			errs = append(errs, fmt.Errorf("%q: a compose item can't have secretPath, secretKey, version or fallback", item.OutputVariable))
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	if _, err := composeOrder(items); err != nil {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+// hasCompose reports whether any item is a compose item.
+func hasCompose(items []SecretToRetrieve) bool {
+	for _, item := range items {
+		if item.Type == TypeCompose {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveCompose evaluates every compose item, after all other items have been resolved.
+// A compose item that references a skipped item is itself treated as missing, so the same DSV_ON_MISSING policy applies.
+// Validation rules are checked as of now.
+func resolveCompose(items []SecretToRetrieve, results []itemResult, policy string, now time.Time) []itemResult {
+	order, err := composeOrder(items)
+	if err != nil {
+		// Already checked by validateItems, so this only happens if the two get out of step.
+		return append(results, itemResult{Status: StatusFailed, Err: err})
+	}
+
+	values := make(map[string]string)
+	skipped := make(map[string]bool)
+	for _, res := range results {
+		name := strings.ToUpper(res.Item.OutputVariable)
+		switch res.Status {
+		case StatusRetrieved, StatusDefault:
+			values[name] = res.Value
+		case StatusSkipped, StatusFailed:
+			skipped[name] = true
+		}
+	}
+
+	for _, item := range order {
+		name := strings.ToUpper(item.OutputVariable)
+		res := composeItem(item, values, skipped)
+		res = checkResult(applyMissingPolicy(res, policy), now)
+		switch res.Status {
+		case StatusComposed, StatusDefault:
+			values[name] = res.Value
+		default:
+			skipped[name] = true
+		}
+		results = append(results, res)
+	}
+	return results
+}
+
+// composeItem evaluates a single compose template.
+func composeItem(item SecretToRetrieve, values map[string]string, skipped map[string]bool) itemResult {
+	refs, err := composeRefs(item)
+	if err != nil {
+		return itemResult{Item: item, Status: StatusFailed, Err: fmt.Errorf("%q: invalid template: %w", item.OutputVariable, err)}
+	}
+	for _, ref := range refs {
+		if skipped[ref] {
+			return itemResult{Item: item, Status: StatusFailed, Err: fmt.Errorf("%q: ref %q has no value: %w", item.OutputVariable, ref, ErrNotFound)}
+		}
+	}
+
+	tmpl, err := parseCompose(item, values)
+	if err != nil {
+		return itemResult{Item: item, Status: StatusFailed, Err: fmt.Errorf("%q: invalid template: %w", item.OutputVariable, err)}
+	}
+	var sb strings.Builder
+	if err := tmpl.Execute(&sb, nil); err != nil {
+		return itemResult{Item: item, Status: StatusFailed, Err: fmt.Errorf("%q: unable to compose value: %w", item.OutputVariable, err)}
+	}
+	val, err := applyTransforms(item.Transforms, sb.String())
+	if err != nil {
+		return itemResult{Item: item, Status: StatusFailed, Err: fmt.Errorf("%q: %w", item.OutputVariable, err)}
+	}
+	pterm.Success.Printfln("%q: composed from %d values", item.OutputVariable, len(refs))
+	return itemResult{Item: item, Status: StatusComposed, Value: val}
+}
@@ -1,17 +1,17 @@
 package dga
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
-	"net/url"
 	"os"
-	"strings"
 	"time"
 
+	"github.com/DelineaXPM/dsv-github-action/dga/dsv"
+	"github.com/DelineaXPM/dsv-github-action/dga/workflow"
 	env "github.com/caarlos0/env/v10"
 	"github.com/pterm/pterm"
 )
@@ -19,18 +19,89 @@ import (
 // defaultTimeout defines default timeout for HTTP requests.
 const defaultTimeout = time.Second * 5
 
+// defaultRetry retries requests to DSV that fail because of the network, rate limiting or DSV being unavailable.
+//
+//nolint:gochecknoglobals // read only policy.
+var defaultRetry = dsv.RetryPolicy{MaxAttempts: 3, Delay: time.Second}
+
 // PermissionReadWriteOwner is the octal permission for Read Write for the owner of the file.
 const PermissionReadWriteOwner = 0o600
 
+// Config is read from the environment, the inputs of action.yml, and from flags by the command line.
+// Fields with a help tag are also flags, named after the variable without DSV_ or after the flag tag, and the help tag is their usage.
 type Config struct {
-	IsCI    bool `env:"GITHUB_ACTIONS"` // IsCI determines if the system is detecting being in CI system.
-	IsDebug bool `env:"RUNNER_DEBUG"`   // IsDebug is based on github action flagging as debug/trace level.
+	IsCI    bool `env:"GITHUB_ACTIONS"`                                                     // IsCI determines if the system is detecting being in CI system.
+	IsDebug bool `env:"RUNNER_DEBUG" flag:"debug" help:"Enable debug messages in the log."` // IsDebug is based on github action flagging as debug/trace level.
 
 	// DSV SPECIFIC ENV VARIABLES.
-	DomainEnv       string `env:"DSV_DOMAIN,required"`                 // Tenant domain name (e.g. example.secretsvaultcloud.com).
-	ClientIDEnv     string `env:"DSV_CLIENT_ID,required"`              // Client ID for authentication.
-	ClientSecretEnv string `json:"-" env:"DSV_CLIENT_SECRET,required"` // Client Secret for authentication.
-	RetrieveEnv     string `env:"DSV_RETRIEVE,required"`               // JSON formatted string with data to retrieve from DSV.
+	DomainEnv         string `env:"DSV_DOMAIN" help:"Tenant domain name, such as example.secretsvaultcloud.com, unless base-url is set."`
+	BaseURLEnv        string `env:"DSV_BASE_URL" help:"URL of the DSV API, such as a reverse proxy, instead of https://<domain>/v1."`
+	AllowCustomDomain bool   `env:"DSV_ALLOW_CUSTOM_DOMAIN" help:"Allow a domain outside DSV's regions, for a private deployment."`
+	Insecure          bool   `env:"DSV_INSECURE" help:"Allow a base URL using plain http, which sends the client secret unencrypted. Only for local testing."`
+	ClientIDEnv       string `env:"DSV_CLIENT_ID,required" help:"Client ID for authentication."`
+	ClientSecretEnv   string `json:"-" env:"DSV_CLIENT_SECRET,required" help:"Client secret for authentication."`
+	RetrieveEnv       string `env:"DSV_RETRIEVE" help:"JSON list of the secrets to retrieve."`
+	RetrieveFileEnv   string `env:"DSV_RETRIEVE_FILE" help:"Path to a JSON file with the secrets to retrieve, instead of retrieve."`
+	OnMissingEnv      string `env:"DSV_ON_MISSING" envDefault:"fail" help:"Policy for a missing secret or key without a default: fail, warn or skip."`
+	ExportMetadata    bool   `env:"DSV_EXPORT_METADATA" help:"Set step outputs with each secret's version, dates, attributes and description."`
+	FollowRefs        bool   `env:"DSV_FOLLOW_REFS" help:"Read the target of values like {\"$ref\": \"path#key\"} instead of exporting the reference."`
+	MaxRefDepth       int    `env:"DSV_MAX_REF_DEPTH" envDefault:"5" help:"Number of references followed from one value."`
+	PolicyFile        string `env:"DSV_POLICY_FILE" help:"Path in the repository of a policy file, read from the default branch."`
+	GitHubToken       string `json:"-" env:"DSV_GITHUB_TOKEN" help:"Token used to read the policy file through the GitHub API."`
+	AllowedPathsEnv   string `env:"DSV_ALLOWED_PATHS" help:"Secret path patterns, one per line or comma separated, that are the only paths that can be read."`
+	DryRun            bool   `env:"DSV_DRY_RUN" help:"Check every item can be read and report the result, without exporting anything."`
+
+	// GITHUB SPECIFIC ENV VARIABLES, used by the policy.
+	EventName    string `env:"GITHUB_EVENT_NAME"`                                  // Name of the event that triggered the workflow.
+	EventPath    string `env:"GITHUB_EVENT_PATH"`                                  // Path of the file with the webhook payload of the event.
+	Ref          string `env:"GITHUB_REF"`                                         // Ref that triggered the workflow.
+	Repository   string `env:"GITHUB_REPOSITORY"`                                  // Owner and name of the repository running the workflow.
+	GitHubAPIURL string `env:"GITHUB_API_URL" envDefault:"https://api.github.com"` // URL of the GitHub API.
+
+	guard    *pathGuard       // guard limits the secrets an untrusted run can read, set by the policy.
+	retry    dsv.RetryPolicy  // retry is how requests to DSV are retried, set by Run.
+	fs       FileSystem       // fs reads the retrieve file and event payload, set by the Runner.
+	commands *workflow.Writer // commands writes annotations, set by the Runner.
+}
+
+// fileSystem returns the FileSystem set by the Runner, or OS.
+func (cfg *Config) fileSystem() FileSystem {
+	if cfg.fs == nil {
+		return OS
+	}
+	return cfg.fs
+}
+
+// workflowCommands returns the workflow command writer set by the Runner, or the one for os.Stdout.
+func (cfg *Config) workflowCommands() *workflow.Writer {
+	if cfg.commands == nil {
+		return workflow.Default()
+	}
+	return cfg.commands
+}
+
+// mask hides each value in the rest of the job's log.
+// Masks are workflow commands, which would only print the values outside of GitHub Actions, and an empty value can't be masked.
+func (cfg *Config) mask(vals ...string) {
+	if !cfg.IsCI {
+		return
+	}
+	for _, val := range vals {
+		if val != "" {
+			cfg.workflowCommands().AddMask(val)
+		}
+	}
+}
+
+// group starts a collapsible group in the log, and returns the func that ends it.
+// Outside of GitHub Actions the group commands would only clutter the log, so nothing is written.
+func (cfg *Config) group(title string) (end func()) {
+	if !cfg.IsCI {
+		return func() {}
+	}
+	commands := cfg.workflowCommands()
+	commands.Group(title)
+	return commands.EndGroup
 }
 
 // SecretToRetrieve defines JSON format of elements that expected in DSV_RETRIEVE list.
@@ -40,157 +111,155 @@ type SecretToRetrieve struct {
 	SecretPath     string `json:"secretPath"`
 	SecretKey      string `json:"secretKey"`
 	OutputVariable string `json:"outputVariable"`
+	// Required forces a failure when the secret or key is missing, regardless of DSV_ON_MISSING.
+	// When false the item is optional, and a missing value is never fatal.
+	// When unset the DSV_ON_MISSING policy applies.
+	Required *bool `json:"required,omitempty"`
+	// Default is exported instead when the secret or key is missing.
+	Default *string `json:"default,omitempty"`
+	// Version pins the secret to a specific version instead of the latest, accepted as a number or a string.
+	Version json.Number `json:"version,omitempty"`
+	// Type is "secret" (the default), "prefix", which exports every secret under SecretPath,
+	// or "compose", which builds a value from Template.
+	Type string `json:"type,omitempty"`
+	// Template builds the value of a compose item, using ref "OUTPUT_VARIABLE" to read the value of another item.
+	Template string `json:"template,omitempty"`
+	// OutputTemplate names each variable exported by a prefix item, from .Prefix, .Path, .RelPath and .Key.
+	OutputTemplate string `json:"outputTemplate,omitempty"`
+	// MaxMatches fails a prefix item that matches more secrets than this, to avoid exporting far more than intended.
+	MaxMatches int `json:"maxMatches,omitempty"`
+	// Fallback is tried in order when the secret or key doesn't exist, and the first one found is exported.
+	Fallback []SecretCandidate `json:"fallback,omitempty"`
+	// Transforms are the names of registered transforms applied in order to the value before it's exported, such as "base64decode".
+	Transforms []string `json:"transforms,omitempty"`
+	// Validate are rules the exported value must pass, checked after any transforms.
+	Validate *ValueRules `json:"validate,omitempty"`
+}
+
+// SecretCandidate is a secret and key to try when the ones before it don't exist.
+//
+//nolint:tagliatelle // Here 'camel' casing is used instead of 'kebab'.
+type SecretCandidate struct {
+	SecretPath string      `json:"secretPath"`
+	SecretKey  string      `json:"secretKey,omitempty"` // SecretKey defaults to the item's secretKey.
+	Version    json.Number `json:"version,omitempty"`
+}
+
+// String identifies the candidate in logs.
+func (c SecretCandidate) String() string {
+	return c.SecretPath + "#" + c.SecretKey
+}
+
+// candidates returns the item's own path and key followed by each fallback, in the order they should be tried.
+func (s SecretToRetrieve) candidates() []SecretCandidate {
+	candidates := []SecretCandidate{{SecretPath: s.SecretPath, SecretKey: s.SecretKey, Version: s.Version}}
+	for _, fallback := range s.Fallback {
+		if fallback.SecretKey == "" {
+			fallback.SecretKey = s.SecretKey
+		}
+		candidates = append(candidates, fallback)
+	}
+	return candidates
+}
+
+// String identifies the item in logs without including its default value.
+func (s SecretToRetrieve) String() string {
+	if s.Type == TypeCompose {
+		return s.OutputVariable
+	}
+	return s.SecretPath + "#" + s.SecretKey
+}
+
+// commandFiles opens the workflow command files, such as GITHUB_ENV, named by environment variables.
+type commandFiles struct {
+	fs     FileSystem
+	lookup lookupFunc
 }
 
-// getGithubEnv reads from the current step target github action
+// osCommandFiles opens the command files of the current step.
+//
+//nolint:gochecknoglobals // read only, the same as OS.
+var osCommandFiles = commandFiles{fs: OS, lookup: os.LookupEnv}
+
+// path reads the path of a workflow command file, such as GITHUB_ENV, from the current step target github action.
 // The path on the runner to the file that sets environment variables from workflow commands.
 // This file is unique to the current step and changes for each step in a job.
 // For example, /home/runner/work/_temp/_runner_file_commands/set_env_87406d6e-4979-4d42-98e1-3dab1f48b13a.
 // For more information, see "Workflow commands for GitHub Actions.".
 // https://docs.github.com/en/actions/using-workflows/workflow-commands-for-github-actions#environment-files
 // The step that creates or updates the environment variable does not have access to the new value, but all subsequent steps in a job will have access.
-func (cfg *Config) getGithubEnv() (string, error) {
-	githubenv, isSet := os.LookupEnv("GITHUB_ENV")
-	if !isSet {
-		return "", fmt.Errorf("GITHUB_ENV is not set")
+func (f commandFiles) path(name string) (string, error) {
+	path, isSet := f.lookup(name)
+	if !isSet || path == "" {
+		return "", fmt.Errorf("%s is not set", name)
 	}
-	pterm.Debug.Printfln("GITHUB_ENV: %s", githubenv)
-	pterm.Success.Printfln("getGithubEnv() success")
-	return githubenv, nil
+	pterm.Debug.Printfln("%s: %s", name, path)
+	pterm.Success.Printfln("commandFiles.path() success")
+	return path, nil
 }
 
-// configureLogging configures Pterm settings for project.
+// configureLogging configures Pterm settings for project, writing workflow commands with commands.
+// Errors, warnings and debug messages are written as workflow commands, so each message is escaped and stays in one annotation.
 // GitHub documents their special syntax here: https://docs.github.com/en/actions/using-workflows/workflow-commands-for-github-actions
-func configureLogging() {
+// It returns a func that restores the printers, as they're global and would otherwise keep writing to commands after a run.
+func configureLogging(commands *workflow.Writer) (restore func()) {
 	pterm.Info.Println("configureLogging()")
-
-	pterm.Error = *pterm.Error.WithShowLineNumber().WithLineNumberOffset(1) //nolint:reassign // changing prefix later, not an issue.
-
-	pterm.Error.Prefix = pterm.Prefix{Text: "::error::", Style: &pterm.Style{}}
-	pterm.Debug.Prefix = pterm.Prefix{Text: "::debug::", Style: &pterm.Style{}}
-
-	pterm.Success.Printfln("configureLogging() success")
-}
-
-func (cfg *Config) sendRequest(c HTTPClient, req *http.Request, out any) error {
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Delinea-DSV-Client", "github-action")
-	resp, err := c.Do(req)
-	if err != nil {
-		pterm.Error.Printfln("sendRequest: %+v", err)
-		return err
+	saved := [...]pterm.PrefixPrinter{pterm.Error, pterm.Warning, pterm.Debug}
+	restore = func() {
+		pterm.Error, pterm.Warning, pterm.Debug = saved[0], saved[1], saved[2] //nolint:reassign // restoring the printers replaced below.
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("%s %s: %s", req.Method, req.URL, resp.Status)
-	}
+	pterm.Error = *pterm.Error.WithShowLineNumber().WithLineNumberOffset(1).WithWriter(commands.CommandWriter("error")) //nolint:reassign // changing prefix later, not an issue.
+	pterm.Warning = *pterm.Warning.WithWriter(commands.CommandWriter("warning"))                                        //nolint:reassign // changing prefix later, not an issue.
+	pterm.Debug = *pterm.Debug.WithWriter(commands.CommandWriter("debug"))                                              //nolint:reassign // changing prefix later, not an issue.
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		pterm.Error.Printfln("sendRequest() unable to read response body: %+v", err)
-		return fmt.Errorf("could not read response body: %w", err)
+	// The command replaces the prefix, and styles would only add color codes to the annotation.
+	for _, printer := range []*pterm.PrefixPrinter{&pterm.Error, &pterm.Warning, &pterm.Debug} {
+		printer.Prefix = pterm.Prefix{Style: &pterm.Style{}}
+		printer.MessageStyle = &pterm.Style{}
 	}
 
-	if err = json.Unmarshal(body, &out); err != nil {
-		pterm.Error.Printfln("Unmarshal(): %+v", err)
-		return fmt.Errorf("could not unmarshal response body: %w", err)
-	}
-	pterm.Success.Printfln("sendRequest() success")
-	return nil
+	pterm.Success.Printfln("configureLogging() success")
+	return restore
 }
 
-func Run() error { //nolint:funlen,cyclop // funlen: this could use refactoring in future to break it apart more, but leaving as is at this time.
-	configureLogging()
+// StatusError is returned when DSV responds with anything other than 200 OK.
+type StatusError = dsv.APIError
+
+// client returns a DSV client that sends requests to apiEndpoint with c.
+func (cfg *Config) client(c HTTPClient, apiEndpoint string) *dsv.Client {
+	return dsv.New(cfg.DomainEnv,
+		dsv.WithBaseURL(apiEndpoint),
+		dsv.WithHTTPClient(c),
+		dsv.WithUserAgent("dsv-github-action"),
+		dsv.WithHeader("Delinea-DSV-Client", "github-action"),
+		dsv.WithRetry(cfg.retry),
+	)
+}
 
+// LoadConfig reads the configuration from environ, or from the environment of the process when environ is nil.
+// The returned Config has every field that could be read, even when the error reports others that are missing or invalid.
+func LoadConfig(environ map[string]string) (Config, error) {
 	cfg := Config{}
-	err := env.Parse(&cfg)
-	if err != nil {
-		pterm.Error.Printfln("env.Parse() %+v", err)
-		return fmt.Errorf("unable to parse env vars: %w", err)
+	if err := env.ParseWithOptions(&cfg, env.Options{Environment: environ}); err != nil {
+		return cfg, &Error{Kind: KindConfig, Hint: "check the required inputs clientId and clientSecret are set", Err: fmt.Errorf("unable to parse env vars: %w", err)}
 	}
-	pterm.Success.Println("parsed environment variables")
-
-	ActionMaskVariable(cfg.ClientIDEnv)
-	ActionMaskVariable(cfg.ClientSecretEnv)
-
-	if cfg.IsDebug {
-		pterm.Info.Println("DEBUG detected, setting debug output to enabled")
-		pterm.EnableDebugMessages()
-		pterm.Debug.Println("debug messages have been enabled")
-
-		// No %v to avoid exposing secret values.
-		pterm.Debug.Printfln("IsCI            : %v", cfg.IsCI)
-		pterm.Debug.Printfln("IsDebug         : %v", cfg.IsDebug)
+	return cfg, nil
+}
 
-		pterm.Debug.Printfln("DomainEnv       : %v", cfg.DomainEnv)
-		pterm.Debug.Println("ClientIDEnv     : ** value exists, but not exposing in logs **")
-		pterm.Debug.Println("ClientSecretEnv : ** value exists, but not exposing in logs **")
-		pterm.Debug.Printfln("RetrieveEnv     : %v", cfg.RetrieveEnv)
-	}
+// NewRunner returns a Runner for cfg that retries requests to DSV the same way as Run.
+func NewRunner(cfg Config) *Runner {
+	cfg.retry = defaultRetry
+	return &Runner{Config: cfg}
+}
 
-	retrievedValues, err := ParseRetrieve(cfg.RetrieveEnv)
+// Run reads the configuration from the environment, then retrieves and exports the secrets as a GitHub Action step.
+func Run() error {
+	cfg, err := LoadConfig(nil)
 	if err != nil {
-		pterm.Error.Printfln("run failure: %v", err)
 		return err
 	}
-
-	apiEndpoint := fmt.Sprintf("https://%s/v1", cfg.DomainEnv)
-	httpClient := &http.Client{Timeout: defaultTimeout}
-
-	token, err := DSVGetToken(httpClient, apiEndpoint, &cfg)
-	if err != nil {
-		pterm.Error.Printfln("authentication failure: %v", err)
-		return fmt.Errorf("unable to get access token")
-	}
-
-	var envFile *os.File
-	if cfg.IsCI {
-		envFile, err = ActionsOpenEnvFile(&cfg)
-		if err != nil {
-			pterm.Error.Printfln("ActionsOpenEnvFile(): %v", err)
-			return err
-		}
-		defer envFile.Close()
-	}
-
-	for _, item := range retrievedValues {
-		pterm.Debug.Printfln("start processing: SecretPath: %s SecretKey: %s", item.SecretPath, item.SecretKey)
-		secret, err := DSVGetSecret(httpClient, apiEndpoint, token, item, &cfg)
-		if err != nil {
-			pterm.Error.Printfln("%q: Failed to fetch secret: %v", item, err)
-			return fmt.Errorf("unable to get secret")
-		}
-
-		secretData, ok := secret["data"].(map[string]any)
-		if !ok {
-			pterm.Error.Printfln("%q: Cannot get data from secret", item)
-			return fmt.Errorf("cannot parse secret")
-		}
-		pterm.Success.Printfln("retrieved successfully: %q", item)
-
-		val, ok := secretData[item.SecretKey].(string)
-		if !ok {
-			pterm.Error.Printfln("%q: Key %q not found in data", item, item.SecretKey)
-			return fmt.Errorf("specified field was not found in data")
-		}
-
-		pterm.Debug.Printfln("%q: Found %q key in data", item, item.SecretKey)
-
-		if !cfg.IsCI {
-			continue
-		}
-
-		outputKey := item.OutputVariable
-
-		if err := ActionExportVariable(envFile, outputKey, val); err != nil { // TODO: this needs to be correctly set to use the right output variable.
-			pterm.Error.Printfln("%q: unable to export env variable: %v", outputKey, err)
-			return fmt.Errorf("cannot set environment variable")
-		}
-		ActionMaskVariable(val)
-		pterm.Success.Printfln("%q: Set env var %q to value in %q", item, strings.ToUpper(outputKey), item.SecretKey)
-	}
-	return nil
+	return NewRunner(cfg).Run()
 }
 
 func ParseRetrieve(retrieve string) ([]SecretToRetrieve, error) {
@@ -208,30 +277,21 @@ type HTTPClient interface {
 	Do(req *http.Request) (*http.Response, error)
 }
 
+// DSVGetToken gets an access token with the client credentials.
 func DSVGetToken(c HTTPClient, apiEndpoint string, cfg *Config) (string, error) {
 	pterm.Info.Println("DSVGetToken()")
-	body := []byte(fmt.Sprintf(
-		`{"grant_type":"client_credentials","client_id":"%s","client_secret":"%s"}`,
-		cfg.ClientIDEnv, cfg.ClientSecretEnv,
-	))
-	endpoint := apiEndpoint + "/token"
-	req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(body))
+	resp, err := cfg.client(c, apiEndpoint).Token(context.Background(), cfg.ClientIDEnv, cfg.ClientSecretEnv)
 	if err != nil {
-		return "", fmt.Errorf("could not build request: %w", err)
-	}
-
-	resp := make(map[string]any)
-	if err = cfg.sendRequest(c, req, &resp); err != nil {
 		return "", fmt.Errorf("API call failed: %w", err)
 	}
-
-	token, ok := resp["accessToken"].(string)
-	if !ok {
+	if resp.AccessToken == "" {
 		return "", fmt.Errorf("could not read access token from response")
 	}
-	return token, nil
+	return resp.AccessToken, nil
 }
 
+// DSVGetSecret returns the whole response for the item's secret.
+// Prefer getSecret, which decodes the response as a dsv.Secret.
 func DSVGetSecret(
 	client HTTPClient,
 	apiEndpoint, accessToken string,
@@ -239,77 +299,101 @@ func DSVGetSecret(
 	cfg *Config,
 ) (map[string]any, error) {
 	pterm.Info.Println("dsvGetSecret()")
-	// Endpoint := apiEndpoint + "/secrets/" + secretPath.
-	endpoint, err := url.JoinPath(apiEndpoint, "secrets", item.SecretPath)
-	if err != nil {
-		pterm.Debug.Println("dsvGetSecret() problem with building url")
-		return nil, fmt.Errorf("unable to build url: %w", err)
+	if err := cfg.guard.check(item.SecretPath); err != nil {
+		return nil, err
 	}
-	req, err := http.NewRequest(http.MethodGet, endpoint, nil)
+	dsvClient := cfg.client(client, apiEndpoint)
+	endpoint, err := dsvClient.SecretURL(item.SecretPath, item.Version.String())
 	if err != nil {
-		pterm.Debug.Printfln("dsvGetSecret(): endpoint: %q", endpoint)
-		return nil, fmt.Errorf("could not build request: %w", err)
+		pterm.Debug.Println("dsvGetSecret() problem with building url")
+		return nil, err
 	}
 
-	req.Header.Set("Authorization", accessToken)
-
 	resp := make(map[string]any)
-	if err = cfg.sendRequest(client, req, &resp); err != nil {
-		pterm.Debug.Printfln("cfg.sendRequest() failure on sending request endpoint:%q req:%+v", endpoint, req)
-
+	if err = dsvClient.Do(context.Background(), http.MethodGet, endpoint, accessToken, nil, &resp); err != nil {
+		pterm.Debug.Printfln("dsvGetSecret() failure on sending request endpoint:%q", endpoint)
 		return nil, fmt.Errorf("API call failed: %w", err)
 	}
 	pterm.Success.Printfln("dsvGetSecret() success")
 	return resp, nil
 }
 
+// getSecret reads a secret, or a specific version of it when version isn't empty.
+func getSecret(c HTTPClient, apiEndpoint, token, path, version string, cfg *Config) (*dsv.Secret, error) {
+	pterm.Info.Println("getSecret()")
+	if err := cfg.guard.check(path); err != nil {
+		return nil, err
+	}
+	secret, err := cfg.client(c, apiEndpoint).GetSecret(context.Background(), token, path, version)
+	if err != nil {
+		return nil, fmt.Errorf("API call failed: %w", err)
+	}
+	if secret.Data == nil {
+		return nil, fmt.Errorf("%q: cannot parse secret", path)
+	}
+	pterm.Success.Printfln("getSecret() success")
+	return secret, nil
+}
+
 // ActionsOpenEnvFile is used for writing secrets back in GitHub.
-func ActionsOpenEnvFile(cfg *Config) (*os.File, error) {
+// The file is opened on the FileSystem of the config, which is OS unless it's run by a Runner with another one.
+func ActionsOpenEnvFile(cfg *Config) (File, error) {
 	pterm.Info.Println("actionsopenEnvFile()")
+	return commandFiles{fs: cfg.fileSystem(), lookup: os.LookupEnv}.open(EnvFileVariable)
+}
 
-	envFileName, err := cfg.getGithubEnv()
+// open opens the workflow command file named by the environment variable for appending.
+func (f commandFiles) open(name string) (File, error) {
+	fileName, err := f.path(name)
 	if err != nil {
-		return nil, fmt.Errorf("GITHUB_ENV environment is not defined")
+		return nil, fmt.Errorf("%s environment is not defined", name)
 	}
-	_, err = os.Stat(envFileName)
+	_, err = f.fs.Stat(fileName)
 	if err != nil {
-		pterm.Error.Printfln("unable to validate envFileName exists: %v", err)
-		return nil, fmt.Errorf("envFileName doesn't seem to exist: %w", err)
+		pterm.Error.Printfln("unable to validate %s exists: %v", name, err)
+		return nil, fmt.Errorf("%s file doesn't seem to exist: %w", name, err)
 	}
-	pterm.Success.Printfln("envfilepath: %s", envFileName)
+	pterm.Success.Printfln("%s path: %s", name, fileName)
 
 	// Confirm permissions of file.
-	if fi, err := os.Lstat(envFileName); err != nil {
+	if fi, err := f.fs.Lstat(fileName); err != nil {
 		pterm.Warning.Println("unable to read permissions of target file")
 	} else {
-		pterm.Info.Printfln("envFileName permission: %#o", fi.Mode().Perm())
+		pterm.Info.Printfln("%s permission: %#o", name, fi.Mode().Perm())
 	}
 
-	envFile, err := os.OpenFile(
-		envFileName,
+	file, err := f.fs.OpenFile(
+		fileName,
 		os.O_APPEND|os.O_CREATE|os.O_WRONLY, //nolint:nosnakecase // these are standard package values and ok to leave snakecase.
 		PermissionReadWriteOwner,
 	)
 	if errors.Is(err, os.ErrNotExist) {
 		// See if we can provide some useful info on the existing permissions.
-		return nil, fmt.Errorf("envfile doesn't exist or has denied permission %s: %w", envFileName, err)
+		return nil, fmt.Errorf("%s file doesn't exist or has denied permission %s: %w", name, fileName, err)
 	}
 	if err != nil {
-		return nil, fmt.Errorf("general error cannot open file %s: %w", envFileName, err)
+		return nil, fmt.Errorf("general error cannot open file %s: %w", fileName, err)
 	}
-	pterm.Success.Printfln("actionsopenEnvFile() success")
-	return envFile, nil
+	pterm.Success.Printfln("commandFiles.open() success")
+	return file, nil
 }
 
-func ActionExportVariable(envFile *os.File, key, val string) error {
+// ActionExportVariable appends key to the environment file, using the same syntax as an exported secret so a multiline value can't inject extra variables.
+func ActionExportVariable(envFile File, key, val string) error {
 	pterm.Info.Println("actionsExportVariable()")
-	if _, err := envFile.WriteString(fmt.Sprintf("%s=%s\n", strings.ToUpper(key), val)); err != nil {
+	entry, err := formatCommandFileEntry(key, val)
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(envFile, entry); err != nil {
 		return fmt.Errorf("could not update %s environment file: %w", envFile.Name(), err)
 	}
 	pterm.Success.Printfln("actionsExportVariable() success")
 	return nil
 }
 
+// ActionMaskVariable masks the value in the rest of the job's logs.
+// Values with several lines are escaped, so no line is left unmasked.
 func ActionMaskVariable(val string) {
-	fmt.Printf("::add-mask::%s\n", val)
+	workflow.AddMask(val)
 }
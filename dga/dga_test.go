@@ -5,8 +5,6 @@ import (
 	"fmt"
 	"io"
 	"net/http"
-	"os"
-	"path/filepath"
 	"testing"
 
 	"github.com/matryer/is"
@@ -66,6 +64,20 @@ func TestParseRetrieveFlag(t *testing.T) {
 			},
 			wantErr: nil,
 		},
+		{
+			name: "pinned version as number or string",
+			retrieve: `
+			[
+				{"secretPath": "folder1/secret1", "secretKey": "key1", "outputVariable": "OUT1", "version": 3},
+				{"secretPath": "folder1/secret1", "secretKey": "key2", "outputVariable": "OUT2", "version": "4"}
+			]
+			`,
+			want: []dga.SecretToRetrieve{
+				{SecretPath: "folder1/secret1", SecretKey: "key1", OutputVariable: "OUT1", Version: "3"},
+				{SecretPath: "folder1/secret1", SecretKey: "key2", OutputVariable: "OUT2", Version: "4"},
+			},
+			wantErr: nil,
+		},
 		{
 			name: "invalid json input structure",
 			retrieve: `
@@ -320,95 +332,3 @@ func TestDsvGetSecret(t *testing.T) {
 		})
 	}
 }
-
-func TestOpenEnvFile(t *testing.T) {
-	// TODO: https://github.com/spf13/afero 👉🏻 look at abstraction so we can easily test filesystem calls.
-	pterm.EnableDebugMessages()
-	pterm.DisableOutput()
-	is := is.New(t)
-	const cacheDir = ".cache"
-	const everyoneReadWriteExec = 0o777
-	is.NoErr(os.MkdirAll(cacheDir, everyoneReadWriteExec))
-	validSecretFile := filepath.Join(cacheDir, ".valid-secret-file")
-	invalidSecretFile := filepath.Join(cacheDir, ".invalid-secret-file")
-	unreadableSecretFile := filepath.Join(cacheDir, ".unreadable-secret-file")
-	// _, err := os.Create(validSecretFile)
-	// is.NoErr(err) // Creating a local file should succeed.
-	// Trunk-ignore(golangci-lint/gosec).
-
-	is.NoErr(os.WriteFile(validSecretFile, []byte("foo=bar"), everyoneReadWriteExec))      // Writing to a local file with unreadable set should succeed.
-	is.NoErr(os.WriteFile(unreadableSecretFile, []byte("foo=bar"), everyoneReadWriteExec)) // Writing to a local file should succeed.
-	is.NoErr(os.Chmod(unreadableSecretFile, os.FileMode(0o111)))                           // Should set permissions to write only.
-
-	defer func() {
-		is.NoErr(os.Chmod(unreadableSecretFile, dga.PermissionReadWriteOwner)) // Should reset cachedir permissions to default.
-		is.NoErr(os.RemoveAll(cacheDir))                                       // Should remove cachedir.
-	}()
-
-	cases := []struct {
-		name       string
-		envs       map[string]string
-		isCI       bool
-		shouldFail bool
-	}{
-		{
-			name: "githubCI should fail when required variables",
-			envs: map[string]string{
-				"CI_JOB_NAME":     "",
-				"CI_PROJECT_PATH": "",
-				"GITHUB_ENV":      "",
-			},
-			isCI:       true,
-			shouldFail: true,
-		},
-		{
-			name: "githubCI should fail when unable to open envfile",
-			envs: map[string]string{
-				"CI_JOB_NAME":     "",
-				"CI_PROJECT_PATH": "",
-				"GITHUB_ENV":      invalidSecretFile,
-			},
-			isCI:       true,
-			shouldFail: true,
-		},
-		{
-			name: "githubCI should fail when file is not readable",
-			envs: map[string]string{
-				"CI_JOB_NAME":     "",
-				"CI_PROJECT_PATH": "",
-				"GITHUB_ENV":      unreadableSecretFile,
-			},
-			isCI:       true,
-			shouldFail: true,
-		},
-		{
-			name: "githubCI should succeed when file exists and is writeable",
-			envs: map[string]string{
-				"CI_JOB_NAME":     "",
-				"CI_PROJECT_PATH": "",
-				"GITHUB_ENV":      validSecretFile,
-			},
-			isCI:       true,
-			shouldFail: false,
-		},
-	}
-
-	for _, tc := range cases {
-		t.Run(tc.name, func(t *testing.T) {
-			is := is.New(t) //nolint:govet // ok to shadow for subtest
-
-			cfg := &dga.Config{
-				IsCI: tc.isCI,
-			}
-			for key, val := range tc.envs {
-				os.Setenv(key, val)
-			}
-			_, err := dga.ActionsOpenEnvFile(cfg)
-			if tc.shouldFail {
-				is.True(err != nil) // Should fail.
-			} else {
-				is.NoErr(err) // Should open envfile without issue.
-			}
-		})
-	}
-}
@@ -0,0 +1,419 @@
+package dga
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pterm/pterm"
+)
+
+// DiagnosisStatus is the outcome of one doctor check.
+type DiagnosisStatus string
+
+const (
+	DiagnosisPass DiagnosisStatus = "pass" // DiagnosisPass is a check that found nothing wrong.
+	DiagnosisWarn DiagnosisStatus = "warn" // DiagnosisWarn is something that may cause a failure, or is worth knowing.
+	DiagnosisFail DiagnosisStatus = "fail" // DiagnosisFail is something that will make the action fail.
+	DiagnosisSkip DiagnosisStatus = "skip" // DiagnosisSkip is a check that couldn't run, because of an earlier failure or missing input.
+)
+
+// Diagnosis is the result of one doctor check, with how to fix it when it didn't pass.
+type Diagnosis struct {
+	Check  string
+	Status DiagnosisStatus
+	Detail string
+	Fix    string
+}
+
+// Limits the doctor checks against.
+const (
+	maxClockSkew      = 5 * time.Minute     // maxClockSkew fails the clock check, as tokens issued by DSV would look expired or not yet valid.
+	warnClockSkew     = time.Minute         // warnClockSkew is enough skew to mention.
+	warnCertExpiresIn = 14 * 24 * time.Hour // warnCertExpiresIn warns of a certificate that will soon expire.
+)
+
+// proxyVariables are the environment variables Go's HTTP client reads a proxy from.
+//
+//nolint:gochecknoglobals // read only list.
+var proxyVariables = []string{"HTTPS_PROXY", "https_proxy", "HTTP_PROXY", "http_proxy", "NO_PROXY", "no_proxy"}
+
+// Doctor runs checks that diagnose why the action can't reach DSV or export secrets, and reports how to fix each problem.
+// It reads and writes through the fields of its Runner, so it can be tested the same way.
+type Doctor struct {
+	runner *Runner
+	// LookupHost resolves the domain, and is net.DefaultResolver.LookupHost when nil.
+	LookupHost func(ctx context.Context, host string) ([]string, error)
+}
+
+// RunDoctor reads the configuration from the environment and runs the doctor checks.
+// Missing inputs don't stop it, as they're reported by the checks that need them.
+func RunDoctor() error {
+	cfg, err := LoadConfig(nil)
+	if err != nil {
+		pterm.Warning.Printfln("some inputs are missing or invalid, the checks that need them will fail: %v", err)
+	}
+	return NewDoctor(NewRunner(cfg)).Run()
+}
+
+// NewDoctor returns a Doctor that checks the configuration of r, reaching DSV and the files the same way r does.
+func NewDoctor(r *Runner) *Doctor {
+	return &Doctor{runner: r}
+}
+
+// lookupHost returns the resolver, or the default one.
+func (d *Doctor) lookupHost() func(ctx context.Context, host string) ([]string, error) {
+	if d.LookupHost == nil {
+		return net.DefaultResolver.LookupHost
+	}
+	return d.LookupHost
+}
+
+// Run runs every check in order, prints a report with how to fix each problem, and fails when any check failed.
+func (d *Doctor) Run() error {
+	cfg := d.runner.Config
+	if cfg.IsCI {
+		defer configureLogging(d.runner.commands())()
+	}
+	cfg.commands = d.runner.commands()
+	cfg.mask(cfg.ClientIDEnv, cfg.ClientSecretEnv)
+
+	results := d.Diagnose()
+	printDiagnoses(results)
+
+	failed := 0
+	for _, res := range results {
+		if res.Status == DiagnosisFail {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return &Error{Kind: KindConfig, Err: fmt.Errorf("%d of %d doctor checks failed", failed, len(results))}
+	}
+	pterm.Success.Printfln("every doctor check passed")
+	return nil
+}
+
+// Diagnose runs every check in order. A check that depends on an earlier one, such as authenticating after connecting, is skipped when that one failed.
+func (d *Doctor) Diagnose() []Diagnosis {
+	cfg := d.runner.Config
+	cfg.fs = d.runner.fileSystem()
+	cfg.commands = d.runner.commands()
+	files := commandFiles{fs: cfg.fs, lookup: d.runner.lookupEnv()}
+	apiEndpoint, endpointErr := cfg.endpoint()
+
+	var results []Diagnosis
+	dns := d.checkDNS(cfg.DomainEnv, apiEndpoint)
+	results = append(results, dns)
+	tlsResult, resp := d.checkTLS(apiEndpoint, endpointErr, dns)
+	results = append(results,
+		tlsResult,
+		checkRegion(cfg.DomainEnv, cfg.BaseURLEnv, cfg.AllowCustomDomain),
+		d.checkClock(resp),
+		d.checkTrust(files.lookup, tlsResult),
+		d.checkToken(&cfg, apiEndpoint, tlsResult),
+		checkEnvFile(files),
+		checkRetrieve(&cfg, files.lookup),
+	)
+	return results
+}
+
+// checkDNS resolves the host of the API endpoint, which is the domain unless the base URL is set.
+func (d *Doctor) checkDNS(domain, apiEndpoint string) Diagnosis {
+	res := Diagnosis{Check: "DNS"}
+	host := domainHost(domain)
+	if u, err := url.Parse(apiEndpoint); err == nil && u.Host != "" {
+		host = u.Hostname()
+	}
+	switch {
+	case host == "":
+		res.Status, res.Detail, res.Fix = DiagnosisFail, "DSV_DOMAIN isn't set", "set the domain input, such as example.secretsvaultcloud.com, or baseUrl"
+		return res
+	case net.ParseIP(host) != nil:
+		res.Status, res.Detail = DiagnosisSkip, host+" is an IP address"
+		return res
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+	addrs, err := d.lookupHost()(ctx, host)
+	if err != nil {
+		res.Status, res.Detail = DiagnosisFail, fmt.Sprintf("unable to resolve %s: %v", host, err)
+		res.Fix = "check the domain is spelled correctly, and the runner's DNS can resolve public names"
+		if suggestion := suggestRegion(domain); suggestion != "" {
+			res.Fix = fmt.Sprintf("check the domain is spelled correctly, did you mean a domain ending in %s?", suggestion)
+		}
+		return res
+	}
+	res.Status, res.Detail = DiagnosisPass, fmt.Sprintf("%s resolves to %s", host, strings.Join(addrs, ", "))
+	return res
+}
+
+// checkTLS connects to DSV through the HTTP client, which uses any proxy, and checks the certificate it presents.
+// The response is returned for the clock check.
+func (d *Doctor) checkTLS(apiEndpoint string, endpointErr error, dns Diagnosis) (Diagnosis, *http.Response) {
+	res := Diagnosis{Check: "TLS"}
+	if endpointErr != nil {
+		res.Status, res.Detail, res.Fix = DiagnosisFail, endpointErr.Error(), "set baseUrl to an https URL of the DSV API, such as https://proxy.example.com/dsv/v1"
+		return res, nil
+	}
+	if dns.Status == DiagnosisFail {
+		res.Status, res.Detail = DiagnosisSkip, "the domain doesn't resolve"
+		return res, nil
+	}
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, apiEndpoint, nil)
+	if err != nil {
+		res.Status, res.Detail, res.Fix = DiagnosisFail, fmt.Sprintf("invalid domain: %v", err), "set the domain input to a host name, without a scheme or path"
+		return res, nil
+	}
+	req.Header.Set("User-Agent", "dsv-github-action")
+	resp, err := d.runner.httpClient().Do(req)
+	if err != nil {
+		res.Status, res.Detail = DiagnosisFail, fmt.Sprintf("unable to connect: %v", err)
+		res.Fix = tlsFix(err)
+		return res, nil
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	if resp.TLS == nil || len(resp.TLS.PeerCertificates) == 0 {
+		res.Status, res.Detail = DiagnosisPass, "connected"
+		if resp.Request != nil && resp.Request.URL.Scheme == "http" {
+			res.Status, res.Detail = DiagnosisWarn, "connected over plain http"
+			res.Fix = "the client secret is sent unencrypted, only use insecure for local testing"
+		}
+		return res, resp
+	}
+	cert := resp.TLS.PeerCertificates[0]
+	res.Status = DiagnosisPass
+	res.Detail = fmt.Sprintf("%s, certificate for %s issued by %s, expires %s",
+		tls.VersionName(resp.TLS.Version), cert.Subject.CommonName, cert.Issuer.CommonName, cert.NotAfter.UTC().Format(time.DateOnly))
+	if left := cert.NotAfter.Sub(d.runner.now()); left < warnCertExpiresIn {
+		res.Status = DiagnosisWarn
+		res.Fix = "the certificate expires soon, if it's issued by a proxy ask its owner to renew it"
+	}
+	return res, resp
+}
+
+// tlsFix suggests how to fix a failure to connect.
+func tlsFix(err error) string {
+	var unknownAuthority x509.UnknownAuthorityError
+	var hostname x509.HostnameError
+	var invalid x509.CertificateInvalidError
+	var dnsErr *net.DNSError
+	var opErr *net.OpError
+	switch {
+	case errors.As(err, &unknownAuthority):
+		return "the certificate isn't trusted, if a proxy inspects TLS add its CA certificate to the runner or point SSL_CERT_FILE at it"
+	case errors.As(err, &hostname):
+		return "the certificate is for another host, check the domain, and that a proxy isn't answering for it"
+	case errors.As(err, &invalid) && invalid.Reason == x509.Expired:
+		return "the certificate has expired or the runner's clock is wrong, check the clock"
+	case errors.As(err, &dnsErr):
+		return "check the domain is spelled correctly, and HTTPS_PROXY when the runner needs a proxy"
+	case errors.As(err, &opErr):
+		return "check the runner can reach the domain on port 443, and HTTPS_PROXY when it needs a proxy"
+	default:
+		return "check the domain and the runner's network"
+	}
+}
+
+// checkRegion checks the domain is in one of DSV's regions, the same as a run, which catches typos before they show up as DNS errors.
+// It's skipped when the base URL is set, as requests don't go to the domain.
+func checkRegion(domain, baseURL string, allowCustom bool) Diagnosis {
+	res := Diagnosis{Check: "Region"}
+	if baseURL != "" {
+		res.Status, res.Detail = DiagnosisSkip, "requests go to DSV_BASE_URL"
+		return res
+	}
+	if domain == "" {
+		res.Status, res.Detail = DiagnosisSkip, "DSV_DOMAIN isn't set"
+		return res
+	}
+	if region := regionOf(domain); region != "" {
+		res.Status, res.Detail = DiagnosisPass, region
+		return res
+	}
+	if allowCustom {
+		res.Status, res.Detail = DiagnosisPass, fmt.Sprintf("%s is allowed by DSV_ALLOW_CUSTOM_DOMAIN", domain)
+		return res
+	}
+	res.Status, res.Detail = DiagnosisFail, fmt.Sprintf("%s isn't in a known region: %s", domain, strings.Join(Regions, ", "))
+	res.Fix = "check the domain, or set allowCustomDomain for a private deployment"
+	if suggestion := suggestRegion(domain); suggestion != "" {
+		res.Fix = fmt.Sprintf("did you mean a domain ending in %s?", suggestion)
+	}
+	return res
+}
+
+// checkClock compares the runner's clock with the Date header of DSV's response.
+func (d *Doctor) checkClock(resp *http.Response) Diagnosis {
+	res := Diagnosis{Check: "Clock"}
+	if resp == nil {
+		res.Status, res.Detail = DiagnosisSkip, "no response from DSV"
+		return res
+	}
+	date, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		res.Status, res.Detail = DiagnosisSkip, "the response has no Date header"
+		return res
+	}
+	skew := d.runner.now().Sub(date)
+	if skew < 0 {
+		skew = -skew
+	}
+	skew = skew.Round(time.Second)
+	switch {
+	case skew > maxClockSkew:
+		res.Status, res.Detail = DiagnosisFail, fmt.Sprintf("the runner's clock is %s off", skew)
+		res.Fix = "sync the runner's clock with NTP, tokens and certificates are checked against it"
+	case skew > warnClockSkew:
+		res.Status, res.Detail = DiagnosisWarn, fmt.Sprintf("the runner's clock is %s off", skew)
+		res.Fix = "sync the runner's clock with NTP"
+	default:
+		res.Status, res.Detail = DiagnosisPass, fmt.Sprintf("within %s of DSV", warnClockSkew)
+	}
+	return res
+}
+
+// checkTrust reports the proxy and extra CA certificates the runner uses, which are the usual cause of TLS failures on self-hosted runners.
+func (d *Doctor) checkTrust(lookup lookupFunc, tlsResult Diagnosis) Diagnosis {
+	res := Diagnosis{Check: "Proxy and CA"}
+	var found []string
+	for _, name := range proxyVariables {
+		if val, ok := lookup(name); ok && val != "" {
+			found = append(found, name+"="+redactProxy(val))
+		}
+	}
+
+	certFile, _ := lookup("SSL_CERT_FILE")
+	if certFile != "" {
+		b, err := d.runner.fileSystem().ReadFile(certFile)
+		if err != nil {
+			res.Status, res.Detail = DiagnosisFail, fmt.Sprintf("unable to read SSL_CERT_FILE: %v", err)
+			res.Fix = "point SSL_CERT_FILE at a readable PEM file of CA certificates"
+			return res
+		}
+		if !x509.NewCertPool().AppendCertsFromPEM(b) {
+			res.Status, res.Detail = DiagnosisFail, "SSL_CERT_FILE has no PEM certificates"
+			res.Fix = "point SSL_CERT_FILE at a PEM file of CA certificates"
+			return res
+		}
+		found = append(found, "SSL_CERT_FILE="+certFile)
+	}
+
+	res.Status, res.Detail = DiagnosisPass, "no proxy or extra CA certificates"
+	if len(found) > 0 {
+		res.Detail = strings.Join(found, ", ")
+	}
+	if tlsResult.Status == DiagnosisFail && len(found) == 0 {
+		res.Status = DiagnosisWarn
+		res.Fix = "if the runner needs a proxy to reach the internet, set HTTPS_PROXY, and SSL_CERT_FILE when the proxy inspects TLS"
+	}
+	return res
+}
+
+// redactProxy removes the password from a proxy URL.
+func redactProxy(val string) string {
+	u, err := url.Parse(val)
+	if err != nil || u.User == nil {
+		return val
+	}
+	return u.Redacted()
+}
+
+// checkToken gets an access token with the client credentials.
+func (d *Doctor) checkToken(cfg *Config, apiEndpoint string, tlsResult Diagnosis) Diagnosis {
+	res := Diagnosis{Check: "Authentication"}
+	switch {
+	case cfg.ClientIDEnv == "" || cfg.ClientSecretEnv == "":
+		res.Status, res.Detail, res.Fix = DiagnosisFail, "DSV_CLIENT_ID or DSV_CLIENT_SECRET isn't set", "set the clientId and clientSecret inputs"
+		return res
+	case tlsResult.Status == DiagnosisFail || tlsResult.Status == DiagnosisSkip:
+		res.Status, res.Detail = DiagnosisSkip, "unable to connect to DSV"
+		return res
+	}
+	if _, err := DSVGetToken(d.runner.httpClient(), apiEndpoint, cfg); err != nil {
+		kind := classify(err, KindAuthentication)
+		res.Status, res.Detail, res.Fix = DiagnosisFail, err.Error(), hint(kind, err, "")
+		return res
+	}
+	res.Status, res.Detail = DiagnosisPass, "got an access token"
+	return res
+}
+
+// checkEnvFile opens GITHUB_ENV for appending, without writing to it.
+func checkEnvFile(files commandFiles) Diagnosis {
+	res := Diagnosis{Check: EnvFileVariable}
+	if _, err := files.path(EnvFileVariable); err != nil {
+		res.Status, res.Detail = DiagnosisSkip, "not running in GitHub Actions"
+		return res
+	}
+	f, err := files.open(EnvFileVariable)
+	if err != nil {
+		res.Status, res.Detail = DiagnosisFail, err.Error()
+		res.Fix = "the step can't set environment variables, check the runner's temp directory is writable and has free space"
+		return res
+	}
+	f.Close()
+	res.Status, res.Detail = DiagnosisPass, "writable"
+	return res
+}
+
+// checkRetrieve parses and validates the retrieve configuration, the same way a run does before requesting anything.
+func checkRetrieve(cfg *Config, lookup lookupFunc) Diagnosis {
+	res := Diagnosis{Check: "Retrieve"}
+	if cfg.RetrieveEnv == "" && cfg.RetrieveFileEnv == "" {
+		res.Status, res.Detail = DiagnosisSkip, "neither DSV_RETRIEVE nor DSV_RETRIEVE_FILE is set"
+		return res
+	}
+	retrieve, err := loadRetrieve(cfg)
+	if err != nil {
+		res.Status, res.Detail, res.Fix = DiagnosisFail, err.Error(), "set one of the retrieve and retrieveFile inputs"
+		return res
+	}
+	items, err := ParseRetrieve(retrieve)
+	if err != nil {
+		res.Status, res.Detail, res.Fix = DiagnosisFail, err.Error(), "retrieve must be a json list of items"
+		return res
+	}
+	resolved, err := resolvePlaceholders(items, lookup)
+	if err != nil {
+		res.Status, res.Detail, res.Fix = DiagnosisFail, err.Error(), "set the environment variables the secret paths use, or give them defaults"
+		return res
+	}
+	if err := validateItems(resolved); err != nil {
+		res.Status, res.Detail, res.Fix = DiagnosisFail, err.Error(), "see the README for the fields of each item type"
+		return res
+	}
+	res.Status, res.Detail = DiagnosisPass, fmt.Sprintf("%d items", len(items))
+	return res
+}
+
+// printDiagnoses renders a table of every check, then how to fix each one that didn't pass.
+func printDiagnoses(results []Diagnosis) {
+	data := [][]string{{"#", "Check", "Status", "Detail"}}
+	for i, res := range results {
+		data = append(data, []string{fmt.Sprint(i + 1), res.Check, string(res.Status), res.Detail})
+	}
+	if err := pterm.DefaultTable.WithHasHeader().WithData(data).Render(); err != nil {
+		pterm.Warning.Printfln("unable to render report: %v", err)
+	}
+	for i, res := range results {
+		if res.Fix == "" {
+			continue
+		}
+		if res.Status == DiagnosisFail {
+			pterm.Error.Printfln("%d. %s: %s", i+1, res.Check, res.Fix)
+		} else {
+			pterm.Warning.Printfln("%d. %s: %s", i+1, res.Check, res.Fix)
+		}
+	}
+}
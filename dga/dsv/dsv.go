@@ -0,0 +1,397 @@
+// Package dsv is a client for the Delinea DevOps Secrets Vault API.
+// It only covers what the action needs: authenticating with client credentials, reading secrets and searching them by path.
+package dsv
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// maxErrorMessage is the most of an error response that's read for its message, so an unexpected page doesn't flood the log.
+const maxErrorMessage = 512
+
+// HTTPClient sends requests, and is satisfied by *http.Client. It's the seam used to test code that calls DSV.
+type HTTPClient interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// RetryPolicy retries requests that fail because of the network, or that DSV rejects as rate limited or unavailable.
+// The zero value doesn't retry.
+type RetryPolicy struct {
+	MaxAttempts int           // MaxAttempts includes the first request, so 3 retries twice.
+	Delay       time.Duration // Delay is doubled after each attempt, unless DSV sends Retry-After.
+	// MaxDelay caps every wait, including one DSV asks for with Retry-After, so a long Retry-After can't outlast the job.
+	// When zero it's the longest backoff, the delay before the last attempt.
+	MaxDelay time.Duration
+}
+
+// maxDelay returns the longest the client waits before an attempt.
+func (p RetryPolicy) maxDelay() time.Duration {
+	if p.MaxDelay > 0 {
+		return p.MaxDelay
+	}
+	return p.Delay << max(p.MaxAttempts-2, 0)
+}
+
+// Client calls the DSV API. Create one with New.
+type Client struct {
+	baseURL   string
+	http      HTTPClient
+	userAgent string
+	headers   http.Header
+	retry     RetryPolicy
+}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithBaseURL replaces the API URL, which is https://<domain>/v1 by default.
+func WithBaseURL(baseURL string) Option {
+	return func(c *Client) { c.baseURL = baseURL }
+}
+
+// WithHTTPClient sends requests with c instead of an *http.Client with a 5 second timeout.
+func WithHTTPClient(hc HTTPClient) Option {
+	return func(c *Client) { c.http = hc }
+}
+
+// WithUserAgent sets the User-Agent header of every request.
+func WithUserAgent(userAgent string) Option {
+	return func(c *Client) { c.userAgent = userAgent }
+}
+
+// WithHeader sets a header on every request, such as the Delinea-DSV-Client header identifying the caller.
+func WithHeader(key, value string) Option {
+	return func(c *Client) { c.headers.Set(key, value) }
+}
+
+// WithRetry retries failed requests with the policy.
+func WithRetry(p RetryPolicy) Option {
+	return func(c *Client) { c.retry = p }
+}
+
+// New returns a client for the tenant domain, such as example.secretsvaultcloud.com.
+func New(domain string, opts ...Option) *Client {
+	c := &Client{
+		baseURL: fmt.Sprintf("https://%s/v1", domain),
+		http:    &http.Client{Timeout: 5 * time.Second}, //nolint:gomnd // same default as the action.
+		headers: make(http.Header),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// BaseURL is the API URL requests are sent to.
+func (c *Client) BaseURL() string {
+	return c.baseURL
+}
+
+// TokenRequest authenticates with client credentials.
+//
+//nolint:tagliatelle // DSV uses snake case for the token request.
+type TokenRequest struct {
+	GrantType    string `json:"grant_type"`
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+}
+
+// TokenResponse holds the access token used for every other request.
+type TokenResponse struct {
+	AccessToken string `json:"accessToken"`
+	TokenType   string `json:"tokenType,omitempty"`
+	ExpiresIn   int    `json:"expiresIn,omitempty"`
+}
+
+// Secret is a DSV secret, with its data and metadata.
+type Secret struct {
+	ID           string         `json:"id,omitempty"`
+	Path         string         `json:"path,omitempty"`
+	Description  string         `json:"description,omitempty"`
+	Version      Version        `json:"version,omitempty"`
+	Created      string         `json:"created,omitempty"`
+	LastModified string         `json:"lastModified,omitempty"`
+	Attributes   map[string]any `json:"attributes,omitempty"`
+	Data         map[string]any `json:"data"`
+}
+
+// Metadata is the part of a secret that describes it, without its data.
+type Metadata struct {
+	Version      Version
+	Created      string
+	LastModified string
+	Attributes   map[string]any
+	Description  string
+}
+
+// Metadata returns the secret without its data.
+func (s *Secret) Metadata() Metadata {
+	return Metadata{
+		Version:      s.Version,
+		Created:      s.Created,
+		LastModified: s.LastModified,
+		Attributes:   s.Attributes,
+		Description:  s.Description,
+	}
+}
+
+// Version is a secret version, which DSV may send as a number or a string.
+type Version string
+
+// UnmarshalJSON accepts a number or a string.
+func (v *Version) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*v = Version(s)
+		return nil
+	}
+	var n json.Number
+	if err := json.Unmarshal(b, &n); err != nil {
+		return fmt.Errorf("version is neither a string nor a number: %w", err)
+	}
+	*v = Version(n.String())
+	return nil
+}
+
+// SearchRequest searches for secrets.
+type SearchRequest struct {
+	SearchText  string
+	SearchField string // SearchField is the field SearchText is matched against, such as "path".
+	Limit       int
+	Cursor      string // Cursor is from the previous page, and empty for the first.
+}
+
+// SearchResponse is one page of search results.
+type SearchResponse struct {
+	Data   []Secret `json:"data"`
+	Cursor string   `json:"cursor"`
+}
+
+// APIError is returned when DSV responds with anything other than 200 OK.
+type APIError struct {
+	Method     string
+	URL        string
+	Status     string
+	StatusCode int
+	Message    string // Message is from the error response body, when there is one.
+}
+
+func (e *APIError) Error() string {
+	if e.Message != "" {
+		return fmt.Sprintf("%s %s: %s: %s", e.Method, e.URL, e.Status, e.Message)
+	}
+	return fmt.Sprintf("%s %s: %s", e.Method, e.URL, e.Status)
+}
+
+// NewAPIError reads the message of an error response, such as {"code":403,"message":"..."} from DSV.
+func NewAPIError(req *http.Request, resp *http.Response) *APIError {
+	apiErr := &APIError{Method: req.Method, URL: req.URL.String(), Status: resp.Status, StatusCode: resp.StatusCode}
+	if resp.Body == nil {
+		return apiErr
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxErrorMessage))
+	if err != nil || len(body) == 0 {
+		return apiErr
+	}
+	//nolint:tagliatelle // OAuth errors use snake case.
+	var errBody struct {
+		Message          string `json:"message"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if json.Unmarshal(body, &errBody) == nil {
+		switch {
+		case errBody.Message != "":
+			apiErr.Message = errBody.Message
+		case errBody.ErrorDescription != "":
+			apiErr.Message = errBody.ErrorDescription
+		default:
+			apiErr.Message = errBody.Error
+		}
+	}
+	return apiErr
+}
+
+// Token gets an access token with client credentials.
+// The request body is JSON encoded, so credentials can contain quotes and backslashes.
+func (c *Client) Token(ctx context.Context, clientID, clientSecret string) (*TokenResponse, error) {
+	body, err := json.Marshal(TokenRequest{GrantType: "client_credentials", ClientID: clientID, ClientSecret: clientSecret})
+	if err != nil {
+		return nil, fmt.Errorf("could not encode request: %w", err)
+	}
+	var resp TokenResponse
+	if err := c.Do(ctx, http.MethodPost, c.baseURL+"/token", "", body, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// GetSecret reads the secret at path, or a specific version of it when version isn't empty.
+func (c *Client) GetSecret(ctx context.Context, token, path, version string) (*Secret, error) {
+	endpoint, err := c.SecretURL(path, version)
+	if err != nil {
+		return nil, err
+	}
+	var secret Secret
+	if err := c.Do(ctx, http.MethodGet, endpoint, token, nil, &secret); err != nil {
+		return nil, err
+	}
+	return &secret, nil
+}
+
+// SecretURL is the URL GetSecret reads the secret from.
+func (c *Client) SecretURL(path, version string) (string, error) {
+	endpoint, err := url.JoinPath(c.baseURL, "secrets", path)
+	if err != nil {
+		return "", fmt.Errorf("unable to build url: %w", err)
+	}
+	if version != "" {
+		// Pinning a version reads that exact revision rather than the latest.
+		endpoint += "?" + url.Values{"version": {version}}.Encode()
+	}
+	return endpoint, nil
+}
+
+// SearchSecrets returns one page of secrets matching the search.
+func (c *Client) SearchSecrets(ctx context.Context, token string, search SearchRequest) (*SearchResponse, error) {
+	endpoint, err := url.JoinPath(c.baseURL, "secrets")
+	if err != nil {
+		return nil, fmt.Errorf("unable to build url: %w", err)
+	}
+	query := url.Values{"searchText": {search.SearchText}}
+	if search.SearchField != "" {
+		query.Set("searchField", search.SearchField)
+	}
+	if search.Limit > 0 {
+		query.Set("limit", strconv.Itoa(search.Limit))
+	}
+	if search.Cursor != "" {
+		query.Set("cursor", search.Cursor)
+	}
+	var page SearchResponse
+	if err := c.Do(ctx, http.MethodGet, endpoint+"?"+query.Encode(), token, nil, &page); err != nil {
+		return nil, err
+	}
+	return &page, nil
+}
+
+// Do sends a request with a JSON body, when body isn't nil, and decodes the JSON response into out.
+// It retries with the client's RetryPolicy, and returns an *APIError for any response other than 200 OK.
+func (c *Client) Do(ctx context.Context, method, endpoint, token string, body []byte, out any) error {
+	attempts := c.retry.MaxAttempts
+	if attempts < 1 {
+		attempts = 1
+	}
+	delay := c.retry.Delay
+	var err error
+	for attempt := 1; ; attempt++ {
+		var retryAfter time.Duration
+		retryAfter, err = c.do(ctx, method, endpoint, token, body, out)
+		if err == nil || attempt >= attempts || !retryable(err) {
+			return err
+		}
+		wait := delay
+		if retryAfter > 0 {
+			wait = retryAfter
+		}
+		wait = min(wait, c.retry.maxDelay())
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(wait):
+		}
+		delay *= 2
+	}
+}
+
+// do sends a single request, returning how long DSV asked to wait before retrying when it sent Retry-After.
+func (c *Client) do(ctx context.Context, method, endpoint, token string, body []byte, out any) (time.Duration, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, reader)
+	if err != nil {
+		return 0, fmt.Errorf("could not build request: %w", err)
+	}
+	for key := range c.headers {
+		req.Header.Set(key, c.headers.Get(key))
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.userAgent != "" {
+		req.Header.Set("User-Agent", c.userAgent)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", token)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var retryAfter time.Duration
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds > 0 {
+			retryAfter = time.Duration(seconds) * time.Second
+		}
+		return retryAfter, NewAPIError(req, resp)
+	}
+
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, fmt.Errorf("could not read response body: %w", err)
+	}
+	if err := json.Unmarshal(b, out); err != nil {
+		return 0, fmt.Errorf("could not unmarshal response body: %w", err)
+	}
+	return 0, nil
+}
+
+// retryable reports whether a request that failed with err may succeed if it's sent again.
+// Timeouts, temporary network failures and DSV being rate limited or unavailable are retried.
+// Errors building or decoding a request, an untrusted certificate or an unsupported URL never change, so they aren't.
+func retryable(err error) bool {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		switch apiErr.StatusCode {
+		case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		default:
+			return false
+		}
+	}
+	var certErr *tls.CertificateVerificationError
+	var unknownAuthority x509.UnknownAuthorityError
+	var hostname x509.HostnameError
+	var invalid x509.CertificateInvalidError
+	var recordErr tls.RecordHeaderError
+	if errors.As(err, &certErr) || errors.As(err, &unknownAuthority) || errors.As(err, &hostname) ||
+		errors.As(err, &invalid) || errors.As(err, &recordErr) {
+		return false
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return dnsErr.IsTemporary || dnsErr.IsTimeout
+	}
+	// A connection that's refused, reset or closed part way may work on the next attempt.
+	var opErr *net.OpError
+	return errors.As(err, &opErr) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF)
+}
@@ -0,0 +1,201 @@
+package dsv
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+// sequence answers each request with the next response, recording the requests.
+type sequence struct {
+	responses []response
+	requests  []*http.Request
+	bodies    []string
+}
+
+type response struct {
+	status int
+	body   string
+	header http.Header
+	err    error
+}
+
+func (s *sequence) Do(req *http.Request) (*http.Response, error) {
+	s.requests = append(s.requests, req)
+	body := ""
+	if req.Body != nil {
+		b, _ := io.ReadAll(req.Body)
+		body = string(b)
+	}
+	s.bodies = append(s.bodies, body)
+	r := s.responses[0]
+	if len(s.responses) > 1 {
+		s.responses = s.responses[1:]
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	return &http.Response{
+		Status:     fmt.Sprintf("%d %s", r.status, http.StatusText(r.status)),
+		StatusCode: r.status,
+		Header:     r.header,
+		Body:       io.NopCloser(bytes.NewReader([]byte(r.body))),
+	}, nil
+}
+
+func TestToken(t *testing.T) {
+	is := is.New(t)
+	seq := &sequence{responses: []response{{status: http.StatusOK, body: `{"accessToken":"abc","tokenType":"bearer","expiresIn":3600}`}}}
+	c := New("example.secretsvaultcloud.com", WithHTTPClient(seq), WithUserAgent("test-agent"), WithHeader("Delinea-DSV-Client", "test"))
+
+	resp, err := c.Token(context.Background(), "id", `se"cr\et`)
+	is.NoErr(err)                     // Should get a token.
+	is.Equal(resp.AccessToken, "abc") // Token should be decoded.
+	is.Equal(resp.ExpiresIn, 3600)    // Expiry should be decoded.
+	req := seq.requests[0]
+	is.Equal(req.URL.String(), "https://example.secretsvaultcloud.com/v1/token") // Token should be requested from the domain.
+	is.Equal(req.Header.Get("User-Agent"), "test-agent")                         // User agent should be set.
+	is.Equal(req.Header.Get("Delinea-DSV-Client"), "test")                       // Extra headers should be set.
+
+	var body TokenRequest
+	is.NoErr(json.Unmarshal([]byte(seq.bodies[0]), &body))                                                  // Body should be valid JSON, even with quotes and backslashes in the credentials.
+	is.Equal(body, TokenRequest{GrantType: "client_credentials", ClientID: "id", ClientSecret: `se"cr\et`}) // Credentials should round trip.
+}
+
+func TestGetSecret(t *testing.T) {
+	is := is.New(t)
+	seq := &sequence{responses: []response{{status: http.StatusOK, body: `{"path":"ci:db","version":3,"created":"2024-01-02T03:04:05Z","attributes":{"ttl":"1h"},"data":{"password":"hunter2"}}`}}}
+	c := New("", WithBaseURL("http://127.0.0.1:8080/v1"), WithHTTPClient(seq))
+
+	secret, err := c.GetSecret(context.Background(), "token", "ci:db", "3")
+	is.NoErr(err)                                                                              // Should get the secret.
+	is.Equal(secret.Data["password"], "hunter2")                                               // Data should be decoded.
+	is.Equal(secret.Metadata().Version, Version("3"))                                          // A numeric version should be read as a string.
+	is.Equal(seq.requests[0].URL.String(), "http://127.0.0.1:8080/v1/secrets/ci:db?version=3") // Version should be requested.
+	is.Equal(seq.requests[0].Header.Get("Authorization"), "token")                             // Token should be sent.
+}
+
+func TestSearchSecrets(t *testing.T) {
+	is := is.New(t)
+	seq := &sequence{responses: []response{{status: http.StatusOK, body: `{"data":[{"path":"ci:db"}],"cursor":"next"}`}}}
+	c := New("example.com", WithHTTPClient(seq))
+
+	page, err := c.SearchSecrets(context.Background(), "token", SearchRequest{SearchText: "ci", SearchField: "path", Limit: 10, Cursor: "abc"})
+	is.NoErr(err)                                                                                                                        // Should search.
+	is.Equal(page.Data[0].Path, "ci:db")                                                                                                 // Results should be decoded.
+	is.Equal(page.Cursor, "next")                                                                                                        // Cursor should be decoded.
+	is.Equal(seq.requests[0].URL.Query(), url.Values{"searchText": {"ci"}, "searchField": {"path"}, "limit": {"10"}, "cursor": {"abc"}}) // Search should be sent as query parameters.
+}
+
+func TestAPIError(t *testing.T) {
+	cases := []struct {
+		name string
+		body string
+		want string
+	}{
+		{name: "dsv", body: `{"code":403,"message":"no policy allows read on secrets:ci:db"}`, want: "no policy allows read on secrets:ci:db"},
+		{name: "oauth", body: `{"error":"invalid_client","error_description":"client credentials are invalid"}`, want: "client credentials are invalid"},
+		{name: "not json", body: `<html>bad gateway</html>`},
+		{name: "empty"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			is := is.New(t)
+			c := New("example.com", WithHTTPClient(&sequence{responses: []response{{status: http.StatusForbidden, body: tc.body}}}))
+			_, err := c.GetSecret(context.Background(), "token", "ci:db", "")
+			var apiErr *APIError
+			is.True(errors.As(err, &apiErr))                  // Should return an APIError.
+			is.Equal(apiErr.StatusCode, http.StatusForbidden) // Status should be kept.
+			is.Equal(apiErr.Message, tc.want)                 // Message should be read from the body.
+		})
+	}
+}
+
+func TestRetry(t *testing.T) {
+	urlErr := func(err error) error { return &url.Error{Op: "Get", URL: "https://example.com", Err: err} }
+	networkErr := urlErr(&net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET})
+	cases := []struct {
+		name         string
+		responses    []response
+		maxDelay     time.Duration
+		wantErr      bool
+		wantRequests int
+	}{
+		{
+			name:         "rate limited then ok",
+			responses:    []response{{status: http.StatusTooManyRequests, header: http.Header{"Retry-After": {"0"}}}, {status: http.StatusOK, body: `{"data":{}}`}},
+			wantRequests: 2,
+		},
+		{
+			name:         "network failure then ok",
+			responses:    []response{{err: networkErr}, {status: http.StatusOK, body: `{"data":{}}`}},
+			wantRequests: 2,
+		},
+		{
+			name:         "timeout then ok",
+			responses:    []response{{err: urlErr(context.DeadlineExceeded)}, {status: http.StatusOK, body: `{"data":{}}`}},
+			wantRequests: 2,
+		},
+		{
+			name:         "untrusted certificate is not retried",
+			responses:    []response{{err: urlErr(&tls.CertificateVerificationError{Err: x509.UnknownAuthorityError{}})}},
+			wantErr:      true,
+			wantRequests: 1,
+		},
+		{
+			name:         "unsupported scheme is not retried",
+			responses:    []response{{err: urlErr(errors.New(`unsupported protocol scheme "ftp"`))}},
+			wantErr:      true,
+			wantRequests: 1,
+		},
+		{
+			name:         "long Retry-After is capped",
+			responses:    []response{{status: http.StatusTooManyRequests, header: http.Header{"Retry-After": {"86400"}}}, {status: http.StatusOK, body: `{"data":{}}`}},
+			wantRequests: 2,
+		},
+		{
+			name:         "Retry-After is capped at MaxDelay",
+			responses:    []response{{status: http.StatusServiceUnavailable, header: http.Header{"Retry-After": {"86400"}}}, {status: http.StatusOK, body: `{"data":{}}`}},
+			maxDelay:     time.Millisecond,
+			wantRequests: 2,
+		},
+		{
+			name:         "unavailable every time",
+			responses:    []response{{status: http.StatusServiceUnavailable}},
+			wantErr:      true,
+			wantRequests: 3,
+		},
+		{
+			name:         "forbidden is not retried",
+			responses:    []response{{status: http.StatusForbidden}},
+			wantErr:      true,
+			wantRequests: 1,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			is := is.New(t)
+			seq := &sequence{responses: tc.responses}
+			c := New("example.com", WithHTTPClient(seq), WithRetry(RetryPolicy{MaxAttempts: 3, Delay: time.Millisecond, MaxDelay: tc.maxDelay}))
+			// Waiting the whole Retry-After would outlast the deadline.
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			_, err := c.GetSecret(ctx, "token", "ci:db", "")
+			is.Equal(err != nil, tc.wantErr)             // Should only fail when every attempt fails.
+			is.Equal(len(seq.requests), tc.wantRequests) // Should only retry what may succeed.
+		})
+	}
+}
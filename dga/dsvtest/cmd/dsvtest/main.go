@@ -0,0 +1,82 @@
+// Command dsvtest serves a fake DSV with https, for running the action without a real tenant.
+//
+// It writes its self-signed certificate to -cert-file, which the action trusts through SSL_CERT_FILE.
+// Without -seed it serves the secret the integration workflow reads, with the values in DefaultSeed.
+package main
+
+import (
+	"crypto/tls"
+	"flag"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/DelineaXPM/dsv-github-action/dga/dsv"
+	"github.com/DelineaXPM/dsv-github-action/dga/dsvtest"
+	"github.com/pterm/pterm"
+)
+
+// DefaultSeed matches the secret and expected values of the integration workflow.
+//
+//nolint:gochecknoglobals // read only default.
+var DefaultSeed = dsvtest.Seed{
+	ClientID:     "dsvtest-client-id",
+	ClientSecret: "dsvtest-client-secret",
+	Secrets: []dsv.Secret{
+		{Path: "ci:tests:dsv-github-action:secret-01", Version: "1", Data: map[string]any{"value1": "dsvtest-value-1", "value2": "dsvtest-value-2"}},
+	},
+}
+
+// readTimeout stops a client that never finishes its request from holding a connection open.
+const readTimeout = 10 * time.Second
+
+func main() {
+	addr := flag.String("addr", "127.0.0.1:8443", "address to listen on")
+	seedFile := flag.String("seed", "", "JSON file with clientId, clientSecret, secrets and forbidden paths, instead of the default seed")
+	certFile := flag.String("cert-file", "", "file to write the PEM encoded certificate to, for clients to trust")
+	hosts := flag.String("hosts", "localhost,127.0.0.1,host.docker.internal", "comma separated names and addresses the certificate is valid for")
+	latency := flag.Duration("latency", 0, "delay before every response")
+	flag.Parse()
+
+	if err := run(*addr, *seedFile, *certFile, strings.Split(*hosts, ","), *latency); err != nil {
+		pterm.Error.Printfln("dsvtest: %v", err)
+		os.Exit(1)
+	}
+}
+
+func run(addr, seedFile, certFile string, hosts []string, latency time.Duration) error {
+	seed := DefaultSeed
+	if seedFile != "" {
+		f, err := os.Open(seedFile)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if seed, err = dsvtest.ReadSeed(f); err != nil {
+			return err
+		}
+	}
+	fake := dsvtest.NewFromSeed(seed)
+	fake.SetLatency(latency)
+
+	cert, certPEM, err := dsvtest.Certificate(hosts...)
+	if err != nil {
+		return err
+	}
+	if certFile != "" {
+		if err := os.WriteFile(certFile, certPEM, 0o600); err != nil { //nolint:gomnd // read write for the owner.
+			return err
+		}
+		pterm.Info.Printfln("dsvtest: certificate written to %s", certFile)
+	}
+
+	pterm.Success.Printfln("dsvtest: serving %d secrets on https://%s/v1", len(seed.Secrets), addr)
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           fake,
+		TLSConfig:         &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12},
+		ReadHeaderTimeout: readTimeout,
+	}
+	return srv.ListenAndServeTLS("", "")
+}
@@ -0,0 +1,290 @@
+// Package dsvtest is a fake DSV for tests, serving the token, secret and search endpoints the action uses.
+// Secrets are seeded in memory, and any path can be made to fail the way DSV does: forbidden by a policy, missing, slow or unavailable.
+// Every request is recorded, so a test can check what was sent.
+package dsvtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DelineaXPM/dsv-github-action/dga/dsv"
+)
+
+// Token is the access token the server issues, and requires for reading secrets.
+const Token = "dsvtest-access-token"
+
+// defaultSearchLimit is the number of secrets in a page of search results when the request doesn't set limit.
+const defaultSearchLimit = 25
+
+// Request is a request the server received.
+type Request struct {
+	Method string
+	Path   string // Path is the unescaped URL path, such as /v1/secrets/ci:db.
+	Query  url.Values
+	Header http.Header
+	Body   []byte
+}
+
+// failure answers the next count requests to an endpoint with status.
+type failure struct {
+	status int
+	count  int
+}
+
+// Server is a fake DSV. Create one with New, then serve it with Start or as an http.Handler.
+type Server struct {
+	mu           sync.Mutex
+	clientID     string
+	clientSecret string
+	secrets      map[string]dsv.Secret // secrets is keyed by the normalized path.
+	forbidden    []string              // forbidden are paths that can't be read, including every secret under them.
+	failures     map[string]*failure   // failures is keyed by endpoint, "token" or a normalized secret path.
+	latency      time.Duration
+	requests     []Request
+}
+
+// New returns a server that issues a token for the client credentials.
+func New(clientID, clientSecret string) *Server {
+	return &Server{
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		secrets:      make(map[string]dsv.Secret),
+		failures:     make(map[string]*failure),
+	}
+}
+
+// normalizePath uses colons between segments, as DSV accepts either colons or slashes in a path.
+func normalizePath(path string) string {
+	return strings.Trim(strings.ReplaceAll(path, "/", ":"), ":")
+}
+
+// AddSecret seeds a secret with data, replacing any secret at the same path.
+func (s *Server) AddSecret(path string, data map[string]any) {
+	s.Add(dsv.Secret{Path: path, Version: "1", Data: data})
+}
+
+// Add seeds a secret with its metadata. When the secret has a version, only that version or the latest can be read.
+func (s *Server) Add(secret dsv.Secret) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	secret.Path = normalizePath(secret.Path)
+	if secret.ID == "" {
+		secret.ID = fmt.Sprintf("dsvtest-%d", len(s.secrets)+1)
+	}
+	s.secrets[secret.Path] = secret
+}
+
+// Forbid answers 403 for the path and every secret under it, as DSV does when no policy lets the client read them.
+func (s *Server) Forbid(path string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.forbidden = append(s.forbidden, normalizePath(path))
+}
+
+// FailToken answers the next count token requests with status, such as 503.
+func (s *Server) FailToken(status, count int) {
+	s.fail("token", status, count)
+}
+
+// FailSecret answers the next count requests for the secret with status, such as 429 or 503.
+func (s *Server) FailSecret(path string, status, count int) {
+	s.fail(normalizePath(path), status, count)
+}
+
+func (s *Server) fail(endpoint string, status, count int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures[endpoint] = &failure{status: status, count: count}
+}
+
+// SetLatency delays every response, to test timeouts and slow tenants.
+func (s *Server) SetLatency(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.latency = d
+}
+
+// Requests returns every request received so far, in order.
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+// Start serves the fake with TLS on a local port, as DSV is only reached with https.
+// Use the returned server's Client to trust its certificate, and its address as the domain. Close it when done.
+func (s *Server) Start() *httptest.Server {
+	return httptest.NewTLSServer(s)
+}
+
+// ServeHTTP implements /v1/token, /v1/secrets/{path} and searching /v1/secrets, and answers 404 for anything else.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	s.mu.Lock()
+	s.requests = append(s.requests, Request{Method: r.Method, Path: r.URL.Path, Query: r.URL.Query(), Header: r.Header.Clone(), Body: body})
+	latency := s.latency
+	s.mu.Unlock()
+
+	if latency > 0 {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-time.After(latency):
+		}
+	}
+
+	switch {
+	case r.URL.Path == "/v1/token" && r.Method == http.MethodPost:
+		s.token(w, body)
+	case r.URL.Path == "/v1/secrets" && r.Method == http.MethodGet:
+		s.search(w, r)
+	case strings.HasPrefix(r.URL.Path, "/v1/secrets/") && r.Method == http.MethodGet:
+		s.secret(w, r)
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+	}
+}
+
+// token issues Token for the client credentials.
+func (s *Server) token(w http.ResponseWriter, body []byte) {
+	if s.failed(w, "token") {
+		return
+	}
+	var req dsv.TokenRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "unable to parse request")
+		return
+	}
+	if req.GrantType != "client_credentials" || req.ClientID != s.clientID || req.ClientSecret != s.clientSecret {
+		writeError(w, http.StatusUnauthorized, "invalid client credentials")
+		return
+	}
+	writeJSON(w, http.StatusOK, dsv.TokenResponse{AccessToken: Token, TokenType: "bearer", ExpiresIn: 3600}) //nolint:gomnd // an hour, the same as DSV.
+}
+
+// secret returns the seeded secret at the path, checking the token and any forbidden path first.
+func (s *Server) secret(w http.ResponseWriter, r *http.Request) {
+	path := normalizePath(strings.TrimPrefix(r.URL.Path, "/v1/secrets/"))
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if token != Token {
+		writeError(w, http.StatusUnauthorized, "invalid or expired token")
+		return
+	}
+	if s.failed(w, path) {
+		return
+	}
+
+	s.mu.Lock()
+	secret, ok := s.secrets[path]
+	forbidden := s.isForbidden(path)
+	s.mu.Unlock()
+
+	version := r.URL.Query().Get("version")
+	switch {
+	case forbidden:
+		writeError(w, http.StatusForbidden, fmt.Sprintf("no policy grants read on secrets:%s", path))
+	case !ok, version != "" && version != string(secret.Version):
+		writeError(w, http.StatusNotFound, "unable to find item with specified identifier")
+	default:
+		writeJSON(w, http.StatusOK, secret)
+	}
+}
+
+// search returns the seeded secrets with searchText anywhere in their path, a page at a time in order of path.
+// Forbidden secrets are left out, as DSV only finds what the client can read.
+// The cursor is the offset of the next page, and is empty on the last page.
+func (s *Server) search(w http.ResponseWriter, r *http.Request) {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if token != Token {
+		writeError(w, http.StatusUnauthorized, "invalid or expired token")
+		return
+	}
+	query := r.URL.Query()
+	if field := query.Get("searchField"); field != "" && field != "path" {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("dsvtest only searches by path, not %q", field))
+		return
+	}
+	limit, offset := defaultSearchLimit, 0
+	if l := query.Get("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n <= 0 {
+			writeError(w, http.StatusBadRequest, "invalid limit")
+			return
+		}
+		limit = n
+	}
+	if c := query.Get("cursor"); c != "" {
+		n, err := strconv.Atoi(c)
+		if err != nil || n < 0 {
+			writeError(w, http.StatusBadRequest, "invalid cursor")
+			return
+		}
+		offset = n
+	}
+
+	text := normalizePath(query.Get("searchText"))
+	s.mu.Lock()
+	var matches []dsv.Secret
+	for path, secret := range s.secrets {
+		if strings.Contains(path, text) && !s.isForbidden(path) {
+			matches = append(matches, secret)
+		}
+	}
+	s.mu.Unlock()
+	sort.Slice(matches, func(i, j int) bool { return matches[i].Path < matches[j].Path })
+
+	page := dsv.SearchResponse{Data: []dsv.Secret{}}
+	if offset < len(matches) {
+		end := min(offset+limit, len(matches))
+		page.Data = matches[offset:end]
+		if end < len(matches) {
+			page.Cursor = strconv.Itoa(end)
+		}
+	}
+	writeJSON(w, http.StatusOK, page)
+}
+
+// isForbidden reports whether the path is, or is under, a forbidden path. The caller holds the lock.
+func (s *Server) isForbidden(path string) bool {
+	for _, f := range s.forbidden {
+		if path == f || strings.HasPrefix(path, f+":") {
+			return true
+		}
+	}
+	return false
+}
+
+// failed answers with an injected failure for the endpoint, if there's one left.
+func (s *Server) failed(w http.ResponseWriter, endpoint string) bool {
+	s.mu.Lock()
+	f, ok := s.failures[endpoint]
+	if !ok || f.count <= 0 {
+		s.mu.Unlock()
+		return false
+	}
+	f.count--
+	status := f.status
+	s.mu.Unlock()
+	writeError(w, status, http.StatusText(status))
+	return true
+}
+
+// writeError answers with the error body DSV uses.
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]any{"code": status, "message": message})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,156 @@
+package dsvtest
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DelineaXPM/dsv-github-action/dga/dsv"
+	"github.com/matryer/is"
+)
+
+// start serves the fake and returns a client for it, closing the server when the test ends.
+func start(t *testing.T, s *Server, opts ...dsv.Option) *dsv.Client {
+	t.Helper()
+	ts := s.Start()
+	t.Cleanup(ts.Close)
+	opts = append([]dsv.Option{dsv.WithBaseURL(ts.URL + "/v1"), dsv.WithHTTPClient(ts.Client())}, opts...)
+	return dsv.New("", opts...)
+}
+
+// statusCode returns the status of an *dsv.APIError, or 0.
+func statusCode(err error) int {
+	var apiErr *dsv.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode
+	}
+	return 0
+}
+
+func TestServer(t *testing.T) {
+	is := is.New(t)
+	s := New("id", "secret")
+	s.AddSecret("ci:db", map[string]any{"password": "hunter2"})
+	s.Add(dsv.Secret{Path: "ci/api", Version: "3", Data: map[string]any{"token": "abc"}})
+	s.AddSecret("ci:private:key", map[string]any{"key": "x"})
+	s.Forbid("ci:private")
+	c := start(t, s)
+	ctx := context.Background()
+
+	_, err := c.Token(ctx, "id", "wrong")
+	is.Equal(statusCode(err), http.StatusUnauthorized) // Wrong credentials should be refused.
+	_, err = c.GetSecret(ctx, "not-a-token", "ci:db", "")
+	is.Equal(statusCode(err), http.StatusUnauthorized) // A secret can't be read without the token.
+
+	resp, err := c.Token(ctx, "id", "secret")
+	is.NoErr(err) // Credentials should be accepted.
+	token := resp.AccessToken
+
+	cases := []struct {
+		name       string
+		path       string
+		version    string
+		wantStatus int
+		wantKey    string
+		wantValue  string
+	}{
+		{name: "seeded secret", path: "ci:db", wantKey: "password", wantValue: "hunter2"},
+		{name: "slashes in the path", path: "ci/db", wantKey: "password", wantValue: "hunter2"},
+		{name: "pinned version", path: "ci:api", version: "3", wantKey: "token", wantValue: "abc"},
+		{name: "other version", path: "ci:api", version: "2", wantStatus: http.StatusNotFound},
+		{name: "missing secret", path: "ci:nope", wantStatus: http.StatusNotFound},
+		{name: "forbidden secret", path: "ci:private:key", wantStatus: http.StatusForbidden},
+		{name: "forbidden path itself", path: "ci:private", wantStatus: http.StatusForbidden},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			is := is.New(t)
+			secret, err := c.GetSecret(ctx, token, tc.path, tc.version)
+			is.Equal(statusCode(err), tc.wantStatus) // Status should match what DSV would answer.
+			if tc.wantStatus == 0 {
+				is.NoErr(err)                                   // Should read the secret.
+				is.Equal(secret.Data[tc.wantKey], tc.wantValue) // Data should be the seeded data.
+			}
+		})
+	}
+}
+
+func TestServerSearch(t *testing.T) {
+	is := is.New(t)
+	s := New("id", "secret")
+	for _, path := range []string{"ci:app:db", "ci:app:api", "ci:app:cache", "ci:other:db", "ci:app:private:key"} {
+		s.AddSecret(path, map[string]any{"key": path})
+	}
+	s.Forbid("ci:app:private")
+	c := start(t, s)
+	ctx := context.Background()
+	resp, err := c.Token(ctx, "id", "secret")
+	is.NoErr(err) // Credentials should be accepted.
+
+	var paths []string
+	cursor := ""
+	for pages := 1; ; pages++ {
+		page, err := c.SearchSecrets(ctx, resp.AccessToken, dsv.SearchRequest{SearchText: "ci:app", SearchField: "path", Limit: 2, Cursor: cursor})
+		is.NoErr(err) // Search should succeed.
+		for _, secret := range page.Data {
+			paths = append(paths, secret.Path)
+		}
+		if page.Cursor == "" {
+			is.Equal(pages, 2) // Results should be split into pages of the limit.
+			break
+		}
+		cursor = page.Cursor
+	}
+	is.Equal(paths, []string{"ci:app:api", "ci:app:cache", "ci:app:db"}) // Every readable match should be found once, in order.
+
+	_, err = c.SearchSecrets(ctx, "not-a-token", dsv.SearchRequest{SearchText: "ci"})
+	is.Equal(statusCode(err), http.StatusUnauthorized) // Search needs the token.
+	_, err = c.SearchSecrets(ctx, resp.AccessToken, dsv.SearchRequest{SearchText: "ci", SearchField: "description"})
+	is.Equal(statusCode(err), http.StatusBadRequest) // Only paths can be searched.
+}
+
+func TestServerFailures(t *testing.T) {
+	is := is.New(t)
+	s := New("id", "secret")
+	s.AddSecret("ci:db", map[string]any{"password": "hunter2"})
+	s.FailToken(http.StatusServiceUnavailable, 1)
+	s.FailSecret("ci:db", http.StatusTooManyRequests, 2)
+	c := start(t, s, dsv.WithRetry(dsv.RetryPolicy{MaxAttempts: 3, Delay: time.Millisecond}))
+	ctx := context.Background()
+
+	resp, err := c.Token(ctx, "id", "secret")
+	is.NoErr(err) // Token should succeed once the injected failure is used up.
+	secret, err := c.GetSecret(ctx, resp.AccessToken, "ci:db", "")
+	is.NoErr(err)                                                               // Secret should succeed after two rate limited attempts.
+	is.Equal(secret.Data["password"], "hunter2")                                // Data should be returned.
+	is.Equal(len(s.Requests()), 5)                                              // Every attempt should be recorded.
+	is.Equal(s.Requests()[0].Path, "/v1/token")                                 // Token should be requested first.
+	is.True(strings.Contains(string(s.Requests()[0].Body), `"client_id":"id"`)) // Request body should be recorded.
+	is.Equal(s.Requests()[4].Header.Get("Authorization"), Token)                // Headers should be recorded.
+}
+
+func TestServerLatency(t *testing.T) {
+	is := is.New(t)
+	s := New("id", "secret")
+	s.SetLatency(time.Second)
+	c := start(t, s)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := c.Token(ctx, "id", "secret")
+	is.True(errors.Is(err, context.DeadlineExceeded)) // A slow response should time out.
+}
+
+func TestReadSeed(t *testing.T) {
+	is := is.New(t)
+	seed, err := ReadSeed(strings.NewReader(`{"clientId":"id","clientSecret":"secret","secrets":[{"path":"ci:db","data":{"password":"hunter2"}}],"forbidden":["ci:private"]}`))
+	is.NoErr(err)                                    // Should read the seed.
+	is.Equal(len(seed.Secrets), 1)                   // Secrets should be read.
+	is.Equal(seed.Forbidden, []string{"ci:private"}) // Forbidden paths should be read.
+
+	_, err = ReadSeed(strings.NewReader(`{"clientID":"id","secret":[]}`))
+	is.True(err != nil) // Unknown fields should fail rather than seed nothing.
+}
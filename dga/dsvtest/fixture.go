@@ -0,0 +1,349 @@
+package dsvtest
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/DelineaXPM/dsv-github-action/dga/dsv"
+)
+
+// Placeholders replace sensitive values in fixtures, so a fixture can be checked in.
+const (
+	PlaceholderClientID     = "<client-id>"
+	PlaceholderClientSecret = "<client-secret>"
+	PlaceholderToken        = "<access-token>"
+	PlaceholderValue        = "<secret-value>"
+)
+
+// Fixture is a recorded set of requests to DSV and the responses to them.
+type Fixture struct {
+	Interactions []Interaction `json:"interactions"`
+}
+
+// Interaction is one request and its response.
+type Interaction struct {
+	Request  RecordedRequest  `json:"request"`
+	Response RecordedResponse `json:"response"`
+}
+
+// RecordedRequest is what identifies a request when it's replayed. Headers aren't recorded, as they carry the token.
+type RecordedRequest struct {
+	Method string          `json:"method"`
+	Path   string          `json:"path"`
+	Query  string          `json:"query,omitempty"`
+	Body   json.RawMessage `json:"body,omitempty"`
+}
+
+// RecordedResponse is the response to replay.
+type RecordedResponse struct {
+	Status int               `json:"status"`
+	Header map[string]string `json:"header,omitempty"`
+	Body   json.RawMessage   `json:"body,omitempty"`
+	Text   string            `json:"text,omitempty"` // Text is a body that isn't JSON, such as an error page from a proxy.
+}
+
+// recordedHeaders are the response headers worth keeping, as the rest vary between runs.
+//
+//nolint:gochecknoglobals // read only list.
+var recordedHeaders = []string{"Content-Type", "Retry-After"}
+
+// sensitiveKeys are fields replaced wherever they appear, with the placeholder for each.
+//
+//nolint:gochecknoglobals // read only map.
+var sensitiveKeys = map[string]string{
+	"client_id":     PlaceholderClientID,
+	"clientId":      PlaceholderClientID,
+	"client_secret": PlaceholderClientSecret,
+	"clientSecret":  PlaceholderClientSecret,
+	"accessToken":   PlaceholderToken,
+	"access_token":  PlaceholderToken,
+	"refreshToken":  PlaceholderToken,
+	"refresh_token": PlaceholderToken,
+}
+
+// Sanitize replaces tokens, credentials and every value in the data of a secret with placeholders.
+// The shape of the body is kept, including the keys of secret data, so a fixture still shows what DSV sends.
+func Sanitize(body []byte) (json.RawMessage, error) {
+	var v any
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil {
+		return nil, fmt.Errorf("body isn't JSON: %w", err)
+	}
+	b, err := json.MarshalIndent(sanitize(v), "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("unable to encode sanitized body: %w", err)
+	}
+	return b, nil
+}
+
+func sanitize(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for key, val := range v {
+			switch {
+			case sensitiveKeys[key] != "":
+				v[key] = sensitiveKeys[key]
+			case key == "data":
+				// A secret's data is an object, and a search response's data is a list of secrets.
+				if data, ok := val.(map[string]any); ok {
+					v[key] = redact(data)
+				} else {
+					v[key] = sanitize(val)
+				}
+			default:
+				v[key] = sanitize(val)
+			}
+		}
+		return v
+	case []any:
+		for i := range v {
+			v[i] = sanitize(v[i])
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+// redact replaces every value in secret data with PlaceholderValue, keeping the keys and nesting.
+func redact(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for key, val := range v {
+			v[key] = redact(val)
+		}
+		return v
+	case []any:
+		for i := range v {
+			v[i] = redact(v[i])
+		}
+		return v
+	default:
+		return PlaceholderValue
+	}
+}
+
+// Recorder sends requests with Client and records sanitized interactions, to be saved as a fixture.
+type Recorder struct {
+	Client dsv.HTTPClient
+
+	mu      sync.Mutex
+	fixture Fixture
+}
+
+// NewRecorder returns a recorder that sends requests with c.
+func NewRecorder(c dsv.HTTPClient) *Recorder {
+	return &Recorder{Client: c}
+}
+
+// Do sends the request and records it with the response. The caller gets the response unchanged.
+func (r *Recorder) Do(req *http.Request) (*http.Response, error) {
+	recorded := RecordedRequest{Method: req.Method, Path: req.URL.Path, Query: req.URL.RawQuery}
+	if req.Body != nil {
+		body, err := io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("dsvtest: unable to read request body: %w", err)
+		}
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		if len(body) > 0 {
+			if recorded.Body, err = Sanitize(body); err != nil {
+				return nil, fmt.Errorf("dsvtest: request body: %w", err)
+			}
+		}
+	}
+
+	resp, err := r.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("dsvtest: unable to read response body: %w", err)
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	response := RecordedResponse{Status: resp.StatusCode, Header: map[string]string{}}
+	for _, key := range recordedHeaders {
+		if val := resp.Header.Get(key); val != "" {
+			response.Header[key] = val
+		}
+	}
+	if len(body) > 0 {
+		if response.Body, err = Sanitize(body); err != nil {
+			// Only JSON can be sanitized, so anything else is recorded without its content.
+			response.Text = fmt.Sprintf("<%d bytes that aren't JSON>", len(body))
+		}
+	}
+
+	r.mu.Lock()
+	r.fixture.Interactions = append(r.fixture.Interactions, Interaction{Request: recorded, Response: response})
+	r.mu.Unlock()
+	return resp, nil
+}
+
+// Fixture returns everything recorded so far.
+func (r *Recorder) Fixture() Fixture {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return Fixture{Interactions: append([]Interaction(nil), r.fixture.Interactions...)}
+}
+
+// Save writes the fixture to the file, after checking it has no value that looks like a real secret.
+func (r *Recorder) Save(name string) error {
+	b, err := json.MarshalIndent(r.Fixture(), "", "  ")
+	if err != nil {
+		return fmt.Errorf("unable to encode fixture: %w", err)
+	}
+	if err := CheckFixture(b); err != nil {
+		return fmt.Errorf("refusing to save %s: %w", name, err)
+	}
+	return os.WriteFile(name, append(b, '\n'), 0o600) //nolint:gomnd // read write for the owner.
+}
+
+// LoadFixture reads a fixture file.
+func LoadFixture(name string) (Fixture, error) {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return Fixture{}, err
+	}
+	var f Fixture
+	if err := json.Unmarshal(b, &f); err != nil {
+		return Fixture{}, fmt.Errorf("unable to parse fixture %s: %w", name, err)
+	}
+	return f, nil
+}
+
+// Replayer answers requests with the responses in a fixture, and is an HTTPClient.
+// Each interaction is used once, in the order recorded, for a request with the same method, path and query.
+type Replayer struct {
+	mu           sync.Mutex
+	interactions []Interaction
+	used         []bool
+}
+
+// NewReplayer returns a replayer for the fixture.
+func NewReplayer(f Fixture) *Replayer {
+	return &Replayer{interactions: f.Interactions, used: make([]bool, len(f.Interactions))}
+}
+
+// Do returns the recorded response to the request, or an error when nothing recorded matches it.
+func (r *Replayer) Do(req *http.Request) (*http.Response, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, in := range r.interactions {
+		if r.used[i] || in.Request.Method != req.Method || in.Request.Path != req.URL.Path || in.Request.Query != req.URL.RawQuery {
+			continue
+		}
+		r.used[i] = true
+		body := []byte(in.Response.Body)
+		if in.Response.Text != "" {
+			body = []byte(in.Response.Text)
+		}
+		header := make(http.Header)
+		for key, val := range in.Response.Header {
+			header.Set(key, val)
+		}
+		return &http.Response{
+			Status:     fmt.Sprintf("%d %s", in.Response.Status, http.StatusText(in.Response.Status)),
+			StatusCode: in.Response.Status,
+			Header:     header,
+			Body:       io.NopCloser(bytes.NewReader(body)),
+			Request:    req,
+		}, nil
+	}
+	return nil, fmt.Errorf("dsvtest: no recorded response for %s %s", req.Method, req.URL.RequestURI())
+}
+
+// Patterns of values that look like real secrets.
+var (
+	jwtPattern  = regexp.MustCompile(`eyJ[A-Za-z0-9_-]{8,}\.[A-Za-z0-9_-]{8,}`)
+	uuidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+	// tokenPattern is the alphabet of tokens and keys: base64, base64url and hex. Paths, dates and headers have other separators.
+	tokenPattern = regexp.MustCompile(`^[A-Za-z0-9+/_=.-]{20,}$`)
+	digit        = regexp.MustCompile(`[0-9]`)
+	letter       = regexp.MustCompile(`[A-Za-z]`)
+)
+
+// minRandomEntropy is in bits per character. Random hex is about 3.7 and base64 about 4.5, while names made of words stay lower.
+const minRandomEntropy = 3.5
+
+// CheckFixture fails when a fixture has a value that looks like a real secret: a JWT, a private key,
+// a long string that looks randomly generated, or secret data that wasn't replaced with PlaceholderValue.
+func CheckFixture(b []byte) error {
+	var v any
+	if err := json.Unmarshal(b, &v); err != nil {
+		return fmt.Errorf("fixture isn't JSON: %w", err)
+	}
+	var errs []error
+	walkStrings(v, "", false, func(path, s string, inData bool) {
+		switch {
+		case inData && s != PlaceholderValue:
+			errs = append(errs, fmt.Errorf("%s: secret data wasn't replaced with %s", path, PlaceholderValue))
+		case jwtPattern.MatchString(s):
+			errs = append(errs, fmt.Errorf("%s: looks like a JWT", path))
+		case strings.Contains(s, "PRIVATE KEY"):
+			errs = append(errs, fmt.Errorf("%s: looks like a private key", path))
+		case looksRandom(s):
+			errs = append(errs, fmt.Errorf("%s: looks like a randomly generated secret", path))
+		}
+	})
+	return errors.Join(errs...)
+}
+
+// walkStrings calls fn with every string in v, and its path, such as interactions[0].response.body.data.password.
+// Anything under a secret's data counts as data, including numbers.
+func walkStrings(v any, path string, inData bool, fn func(path, s string, inData bool)) {
+	switch v := v.(type) {
+	case map[string]any:
+		for key, val := range v {
+			_, isObject := val.(map[string]any)
+			walkStrings(val, path+"."+key, inData || (key == "data" && isObject), fn)
+		}
+	case []any:
+		for i, val := range v {
+			walkStrings(val, fmt.Sprintf("%s[%d]", path, i), inData, fn)
+		}
+	case string:
+		fn(strings.TrimPrefix(path, "."), v, inData)
+	case nil:
+	default:
+		fn(strings.TrimPrefix(path, "."), fmt.Sprint(v), inData)
+	}
+}
+
+// looksRandom reports whether s looks like a generated token: long, in the alphabet of tokens, mixing letters and digits,
+// with the entropy of random text. UUIDs are allowed, as DSV uses them for ids.
+func looksRandom(s string) bool {
+	if !tokenPattern.MatchString(s) || uuidPattern.MatchString(s) || !digit.MatchString(s) || !letter.MatchString(s) {
+		return false
+	}
+	return entropy(s) >= minRandomEntropy
+}
+
+// entropy is the Shannon entropy of s in bits per character.
+func entropy(s string) float64 {
+	counts := make(map[rune]int)
+	n := 0
+	for _, r := range s {
+		counts[r]++
+		n++
+	}
+	var e float64
+	for _, c := range counts {
+		p := float64(c) / float64(n)
+		e -= p * math.Log2(p)
+	}
+	return e
+}
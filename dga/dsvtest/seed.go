@@ -0,0 +1,91 @@
+package dsvtest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"time"
+
+	"github.com/DelineaXPM/dsv-github-action/dga/dsv"
+)
+
+// Seed is everything a fake starts with, read from JSON by the standalone binary.
+//
+//nolint:tagliatelle // Here 'camel' casing is used instead of 'kebab'.
+type Seed struct {
+	ClientID     string       `json:"clientId"`
+	ClientSecret string       `json:"clientSecret"`
+	Secrets      []dsv.Secret `json:"secrets"`
+	Forbidden    []string     `json:"forbidden,omitempty"` // Forbidden are paths answered with 403, including every secret under them.
+}
+
+// ReadSeed decodes a seed, rejecting unknown fields so a typo doesn't quietly seed nothing.
+func ReadSeed(r io.Reader) (Seed, error) {
+	var seed Seed
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&seed); err != nil {
+		return Seed{}, fmt.Errorf("unable to parse seed: %w", err)
+	}
+	return seed, nil
+}
+
+// NewFromSeed returns a server with the seed's credentials, secrets and forbidden paths.
+func NewFromSeed(seed Seed) *Server {
+	s := New(seed.ClientID, seed.ClientSecret)
+	for _, secret := range seed.Secrets {
+		s.Add(secret)
+	}
+	for _, path := range seed.Forbidden {
+		s.Forbid(path)
+	}
+	return s
+}
+
+// certificateValidity is how long a certificate from Certificate is valid, long enough for any test run.
+const certificateValidity = 24 * time.Hour
+
+// Certificate creates a self-signed certificate for the hosts, which are names or IP addresses.
+// It returns the certificate to serve, and the PEM encoded certificate for clients to trust, such as with SSL_CERT_FILE.
+func Certificate(hosts ...string) (tls.Certificate, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, nil, fmt.Errorf("unable to generate key: %w", err)
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128)) //nolint:gomnd // 128 bit serial number.
+	if err != nil {
+		return tls.Certificate{}, nil, fmt.Errorf("unable to generate serial number: %w", err)
+	}
+	template := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "dsvtest"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(certificateValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, nil, fmt.Errorf("unable to create certificate: %w", err)
+	}
+	cert := tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+	return cert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), nil
+}
@@ -0,0 +1,60 @@
+package dga
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/pterm/pterm"
+)
+
+// parseBaseURL checks a base URL of the DSV API and returns it without a trailing slash.
+// It has to use https, unless insecure allows plain http for local testing.
+// Errors never include credentials in the URL.
+func parseBaseURL(raw string, insecure bool) (string, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", errors.New("baseUrl isn't a valid URL")
+	}
+	switch {
+	case u.Scheme == "http" && !insecure:
+		return "", fmt.Errorf("baseUrl %q uses plain http, which sends the client secret unencrypted, set insecure only for local testing", u.Redacted())
+	case u.Scheme != "https" && u.Scheme != "http":
+		return "", fmt.Errorf("baseUrl %q must use https", u.Redacted())
+	case u.Host == "":
+		return "", fmt.Errorf("baseUrl %q has no host", u.Redacted())
+	case u.User != nil:
+		return "", fmt.Errorf("baseUrl %q can't include credentials", u.Redacted())
+	case u.RawQuery != "" || u.Fragment != "":
+		return "", fmt.Errorf("baseUrl %q can't have a query or fragment", u.Redacted())
+	}
+	return strings.TrimSuffix(u.String(), "/"), nil
+}
+
+// endpoint returns the URL of the DSV API, which is the base URL when it's set, or https://<domain>/v1.
+func (cfg *Config) endpoint() (string, error) {
+	if cfg.BaseURLEnv == "" && cfg.DomainEnv == "" {
+		return "", errors.New("set the domain, or baseUrl")
+	}
+	if cfg.BaseURLEnv == "" {
+		return fmt.Sprintf("https://%s/v1", cfg.DomainEnv), nil
+	}
+	return parseBaseURL(cfg.BaseURLEnv, cfg.Insecure)
+}
+
+// apiEndpoint returns the URL of the DSV API, after checking the domain and base URL.
+// The domain's region is only checked when requests go to it, as a base URL doesn't need a domain.
+func (cfg *Config) apiEndpoint() (string, error) {
+	endpoint, err := cfg.endpoint()
+	if err == nil && cfg.BaseURLEnv == "" {
+		err = checkDomain(cfg.DomainEnv, cfg.AllowCustomDomain)
+	}
+	if err != nil {
+		return "", &Error{Kind: KindConfig, Err: err}
+	}
+	if strings.HasPrefix(endpoint, "http:") {
+		pterm.Warning.Printfln("baseUrl uses plain http, the client secret is sent unencrypted, only use it for local testing")
+	}
+	return endpoint, nil
+}
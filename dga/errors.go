@@ -0,0 +1,162 @@
+package dga
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+)
+
+// ErrKeyMissing is wrapped by errors for a secret that exists without the requested key.
+// It wraps ErrNotFound, so a missing key is treated the same as a missing secret by fallbacks and DSV_ON_MISSING.
+var ErrKeyMissing = fmt.Errorf("key %w", ErrNotFound)
+
+// Kind is the category of a failure, which decides the exit code.
+type Kind int
+
+// Kinds of failure, each with its own exit code.
+const (
+	KindUnknown        Kind = iota + 1 // KindUnknown is any other failure.
+	KindConfig                         // KindConfig is invalid inputs or retrieve configuration.
+	KindAuthentication                 // KindAuthentication is a failure to get an access token with the client credentials.
+	KindForbidden                      // KindForbidden is a secret a DSV policy, or the action's policy, doesn't allow reading.
+	KindNotFound                       // KindNotFound is a secret that doesn't exist.
+	KindKeyMissing                     // KindKeyMissing is a secret without the requested key.
+	KindRateLimited                    // KindRateLimited is DSV refusing requests because too many were sent.
+	KindNetwork                        // KindNetwork is DSV being unreachable.
+)
+
+// String describes the kind in logs.
+func (k Kind) String() string {
+	switch k {
+	case KindConfig:
+		return "invalid configuration"
+	case KindAuthentication:
+		return "authentication failed"
+	case KindForbidden:
+		return "forbidden by policy"
+	case KindNotFound:
+		return "not found"
+	case KindKeyMissing:
+		return "key missing"
+	case KindRateLimited:
+		return "rate limited"
+	case KindNetwork:
+		return "network error"
+	default:
+		return "error"
+	}
+}
+
+// exitCodeOffset moves the exit codes of the known kinds past 2, which Go exits with for a panic, so a crash can't be mistaken for one of them.
+const exitCodeOffset = 1
+
+// ExitCode is the process exit code for the kind, documented in the README.
+// Any other failure exits 1, and the known kinds start at 3.
+func (k Kind) ExitCode() int {
+	if k <= KindUnknown || k > KindNetwork {
+		return int(KindUnknown)
+	}
+	return int(k) + exitCodeOffset
+}
+
+// Error is a failure of a known kind, with a hint on how to fix it.
+type Error struct {
+	Kind Kind
+	Hint string
+	Err  error
+}
+
+func (e *Error) Error() string {
+	if e.Hint == "" {
+		return fmt.Sprintf("%s: %v", e.Kind, e.Err)
+	}
+	return fmt.Sprintf("%s: %v (hint: %s)", e.Kind, e.Err, e.Hint)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// ExitCode returns the exit code for err, which is 0 for nil and 1 for a failure of unknown kind.
+// When several items failed the first one decides.
+func ExitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Kind.ExitCode()
+	}
+	return classify(err, KindUnknown).ExitCode()
+}
+
+// classify returns the kind of err, or fallback when it isn't recognized.
+func classify(err error, fallback Kind) Kind {
+	var e *Error
+	var statusErr *StatusError
+	var netErr net.Error
+	var urlErr *url.Error
+	switch {
+	case errors.As(err, &e):
+		return e.Kind
+	case errors.Is(err, ErrNotAllowed):
+		return KindForbidden
+	case errors.Is(err, ErrKeyMissing):
+		return KindKeyMissing
+	case errors.Is(err, ErrNotFound):
+		return KindNotFound
+	case errors.As(err, &statusErr):
+		switch statusErr.StatusCode {
+		case http.StatusUnauthorized:
+			return KindAuthentication
+		case http.StatusForbidden:
+			return KindForbidden
+		case http.StatusNotFound:
+			return KindNotFound
+		case http.StatusTooManyRequests:
+			return KindRateLimited
+		}
+	case errors.As(err, &netErr), errors.As(err, &urlErr):
+		return KindNetwork
+	}
+	return fallback
+}
+
+// newError classifies err and attaches a hint, using fallback when the kind isn't recognized.
+// The path is the secret the error is about, used in hints, and may be empty.
+func newError(err error, fallback Kind, path string) *Error {
+	var e *Error
+	if errors.As(err, &e) {
+		return e
+	}
+	kind := classify(err, fallback)
+	return &Error{Kind: kind, Hint: hint(kind, err, path), Err: err}
+}
+
+// hint suggests how to fix a failure of the kind.
+func hint(kind Kind, err error, path string) string {
+	switch kind {
+	case KindAuthentication:
+		return "check clientId and clientSecret are for a client of the domain, and the client hasn't been deleted"
+	case KindForbidden:
+		if errors.Is(err, ErrNotAllowed) {
+			return "the action's policy refused the path, check allowedPaths and policyFile"
+		}
+		if path != "" {
+			return fmt.Sprintf("check that the role has a read policy on secrets:%s", normalizePath(path))
+		}
+		return "check that the role has a read policy on the secret"
+	case KindNotFound:
+		return "check the secretPath exists, and the domain is for the region it's stored in"
+	case KindKeyMissing:
+		return "check the secretKey is in the data of the secret, keys are case sensitive"
+	case KindRateLimited:
+		return "DSV is limiting requests, retry later or retrieve fewer secrets"
+	case KindNetwork:
+		return "check the domain is correct and the runner can reach it"
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,58 @@
+package dga
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func TestClassify(t *testing.T) {
+	cases := []struct {
+		name string
+		err  error
+		want Kind
+	}{
+		{name: "unauthorized", err: &StatusError{StatusCode: http.StatusUnauthorized}, want: KindAuthentication},
+		{name: "forbidden", err: &StatusError{StatusCode: http.StatusForbidden}, want: KindForbidden},
+		{name: "action policy", err: fmt.Errorf("x: %w", ErrNotAllowed), want: KindForbidden},
+		{name: "not found", err: fmt.Errorf("%w: %w", ErrNotFound, &StatusError{StatusCode: http.StatusNotFound}), want: KindNotFound},
+		{name: "key missing", err: fmt.Errorf("x: %w", ErrKeyMissing), want: KindKeyMissing},
+		{name: "rate limited", err: &StatusError{StatusCode: http.StatusTooManyRequests}, want: KindRateLimited},
+		{name: "network", err: &url.Error{Op: "Get", URL: "https://example.com", Err: errors.New("connection refused")}, want: KindNetwork},
+		{name: "server error", err: &StatusError{StatusCode: http.StatusInternalServerError}, want: KindUnknown},
+		{name: "typed", err: fmt.Errorf("x: %w", &Error{Kind: KindConfig, Err: errors.New("bad")}), want: KindConfig},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			is := is.New(t)
+			is.Equal(classify(tc.err, KindUnknown), tc.want) // Error should be classified.
+		})
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	is := is.New(t)
+	is.Equal(ExitCode(nil), 0)                     // Success should exit 0.
+	is.Equal(ExitCode(errors.New("x")), 1)         // Unknown failures should exit 1.
+	is.True(errors.Is(ErrKeyMissing, ErrNotFound)) // A missing key should still count as missing for fallbacks.
+
+	codes := make(map[int]bool)
+	for kind := KindUnknown; kind <= KindNetwork; kind++ {
+		codes[kind.ExitCode()] = true
+	}
+	is.Equal(len(codes), int(KindNetwork)) // Every kind should have a distinct exit code.
+	is.True(!codes[2])                     // 2 is left to Go, which exits with it for a panic.
+	is.Equal(KindConfig.ExitCode(), 3)     // The known kinds should start at 3.
+
+	joined := fmt.Errorf("unable to retrieve 2 of 2 secrets: %w", errors.Join(
+		newError(fmt.Errorf("%q: %w", "ci:db", &StatusError{StatusCode: http.StatusForbidden}), KindUnknown, "ci/db"),
+		newError(fmt.Errorf("x: %w", ErrNotFound), KindUnknown, "ci:api"),
+	))
+	is.Equal(ExitCode(joined), KindForbidden.ExitCode())                                                // First failure should decide the exit code.
+	is.True(strings.Contains(joined.Error(), "check that the role has a read policy on secrets:ci:db")) // Forbidden should hint at the policy.
+}
@@ -0,0 +1,166 @@
+package dga
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/pterm/pterm"
+)
+
+// EnvFileVariable is the environment variable GitHub uses to point at the file that sets environment variables for later steps.
+const EnvFileVariable = "GITHUB_ENV"
+
+// outputVariablePattern is the set of names GitHub accepts for environment variables set through GITHUB_ENV.
+var outputVariablePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// exportEntry is a single key and value to write to a workflow command file.
+type exportEntry struct {
+	key string
+	val string
+}
+
+// exportBatch collects writes to workflow command files (GITHUB_ENV and friends) so they can be committed together.
+// Nothing touches the filesystem until commit is called, so a failure while resolving secrets leaves every file untouched.
+type exportBatch struct {
+	targets []string                 // targets keeps the order the files were first added to.
+	entries map[string][]exportEntry // entries is keyed by the environment variable naming the target file, such as GITHUB_ENV.
+}
+
+func newExportBatch() *exportBatch {
+	return &exportBatch{entries: make(map[string][]exportEntry)}
+}
+
+// add queues key=val to be written to the file named by the target environment variable.
+func (b *exportBatch) add(target, key, val string) {
+	if _, ok := b.entries[target]; !ok {
+		b.targets = append(b.targets, target)
+	}
+	b.entries[target] = append(b.entries[target], exportEntry{key: key, val: val})
+}
+
+// stagedFile is a command file opened for commit, with the size it had before anything was written to it.
+type stagedFile struct {
+	file         File
+	originalSize int64
+}
+
+// commit writes every queued entry to the command files as a single operation.
+// Every entry is formatted and all files are opened before any are written, and if a write fails then all files already written are truncated back to their original size.
+func (b *exportBatch) commit(files commandFiles) error {
+	pterm.Info.Println("exportBatch.commit()")
+
+	contents := make([]string, 0, len(b.targets))
+	for _, target := range b.targets {
+		var sb strings.Builder
+		for _, e := range b.entries[target] {
+			entry, err := formatCommandFileEntry(e.key, e.val)
+			if err != nil {
+				return err
+			}
+			sb.WriteString(entry)
+		}
+		contents = append(contents, sb.String())
+	}
+
+	staged := make([]stagedFile, 0, len(b.targets))
+	defer func() {
+		for _, sf := range staged {
+			sf.file.Close()
+		}
+	}()
+
+	for _, target := range b.targets {
+		f, err := files.open(target)
+		if err != nil {
+			return err
+		}
+		fi, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return fmt.Errorf("unable to stat %s file: %w", target, err)
+		}
+		staged = append(staged, stagedFile{file: f, originalSize: fi.Size()})
+	}
+
+	for i, target := range b.targets {
+		if _, err := io.WriteString(staged[i].file, contents[i]); err != nil {
+			pterm.Error.Printfln("unable to write %s file, rolling back: %v", target, err)
+			return errors.Join(
+				fmt.Errorf("could not update %s file: %w", target, err),
+				rollback(staged[:i+1]),
+			)
+		}
+	}
+	pterm.Success.Printfln("exportBatch.commit() success")
+	return nil
+}
+
+// formatCommandFileEntry formats key and val using the environment file syntax GitHub documents.
+// Values containing a newline use the multiline syntax with a random delimiter, as the single line form would let a value inject extra variables.
+// The runner reads a CRLF as a line break and joins lines with LF, so a value it would change is refused rather than exported altered.
+// https://docs.github.com/en/actions/using-workflows/workflow-commands-for-github-actions#multiline-strings
+func formatCommandFileEntry(key, val string) (string, error) {
+	key = strings.ToUpper(key)
+	if strings.Contains(val, "\r\n") || strings.HasSuffix(val, "\r") {
+		return "", fmt.Errorf("%s: value has CRLF line breaks, which GitHub would change to LF, use the lf transform to convert them", key)
+	}
+	if !strings.ContainsAny(val, "\r\n") {
+		return fmt.Sprintf("%s=%s\n", key, val), nil
+	}
+	delimiter, err := heredocDelimiter(val)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s<<%s\n%s\n%s\n", key, delimiter, val, delimiter), nil
+}
+
+// heredocDelimiterBytes is the number of random bytes in a multiline delimiter.
+const heredocDelimiterBytes = 16
+
+// heredocDelimiter returns a random delimiter that doesn't appear in val.
+func heredocDelimiter(val string) (string, error) {
+	for {
+		b := make([]byte, heredocDelimiterBytes)
+		if _, err := rand.Read(b); err != nil {
+			return "", fmt.Errorf("unable to generate delimiter: %w", err)
+		}
+		delimiter := "ghadelimiter_" + hex.EncodeToString(b)
+		if !strings.Contains(val, delimiter) {
+			return delimiter, nil
+		}
+	}
+}
+
+// rollback truncates each staged file back to the size it had before commit started.
+func rollback(staged []stagedFile) error {
+	var errs []error
+	for _, sf := range staged {
+		if err := sf.file.Truncate(sf.originalSize); err != nil {
+			errs = append(errs, fmt.Errorf("unable to roll back %s: %w", sf.file.Name(), err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// validateOutputVariables checks every output variable is a name GitHub will accept and that no two items export the same name.
+func validateOutputVariables(items []SecretToRetrieve) error {
+	var errs []error
+	seen := make(map[string]bool, len(items))
+	for _, item := range items {
+		name := strings.ToUpper(item.OutputVariable)
+		if !outputVariablePattern.MatchString(name) {
+			errs = append(errs, fmt.Errorf("%q: outputVariable %q is not a valid environment variable name", item.SecretPath, item.OutputVariable))
+			continue
+		}
+		if seen[name] {
+			errs = append(errs, fmt.Errorf("%q: outputVariable %q is used by more than one item", item.SecretPath, item.OutputVariable))
+		}
+		seen[name] = true
+	}
+	return errors.Join(errs...)
+}
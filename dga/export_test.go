@@ -0,0 +1,167 @@
+package dga
+
+import (
+	"errors"
+	"io/fs"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/matryer/is"
+	"github.com/pterm/pterm"
+)
+
+// memCommandFiles returns command files on fsys, with the paths named by env.
+func memCommandFiles(fsys FileSystem, env map[string]string) commandFiles {
+	return commandFiles{fs: fsys, lookup: func(key string) (string, bool) {
+		val, ok := env[key]
+		return val, ok
+	}}
+}
+
+func TestExportBatchCommit(t *testing.T) {
+	pterm.DisableOutput()
+	is := is.New(t)
+
+	fsys := NewMemFS()
+	fsys.WriteFile("/runner/env", []byte("EXISTING=1\n"), PermissionReadWriteOwner)
+	files := memCommandFiles(fsys, map[string]string{EnvFileVariable: "/runner/env"})
+
+	batch := newExportBatch()
+	batch.add(EnvFileVariable, "first", "one")
+	batch.add(EnvFileVariable, "SECOND", "two")
+	is.NoErr(batch.commit(files)) // Commit should succeed.
+
+	got, err := fsys.ReadFile("/runner/env")
+	is.NoErr(err)                                                // Should read env file.
+	is.Equal(string(got), "EXISTING=1\nFIRST=one\nSECOND=two\n") // All entries should be appended in order.
+}
+
+func TestExportBatchCommitLeavesFilesUntouchedOnFailure(t *testing.T) {
+	pterm.DisableOutput()
+	cases := []struct {
+		name  string
+		setup func(fsys *MemFS)
+		want  error // want is the cause the commit error should wrap.
+	}{
+		{
+			name:  "output file missing",
+			setup: func(fsys *MemFS) {},
+			want:  fs.ErrNotExist,
+		},
+		{
+			name: "output file read only",
+			setup: func(fsys *MemFS) {
+				fsys.WriteFile("/runner/output", nil, 0o400)
+			},
+			want: fs.ErrPermission,
+		},
+		{
+			name: "disk full",
+			setup: func(fsys *MemFS) {
+				fsys.WriteFile("/runner/output", []byte("KEPT=1\n"), PermissionReadWriteOwner)
+				fsys.SetFreeSpace(0)
+			},
+			want: syscall.ENOSPC,
+		},
+		{
+			name: "partial write of the output file",
+			setup: func(fsys *MemFS) {
+				fsys.WriteFile("/runner/output", []byte("KEPT=1\n"), PermissionReadWriteOwner)
+				fsys.SetFreeSpace(int64(len("FIRST=one\nSECO")))
+			},
+			want: syscall.ENOSPC,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			is := is.New(t)
+			fsys := NewMemFS()
+			fsys.WriteFile("/runner/env", []byte("EXISTING=1\n"), PermissionReadWriteOwner)
+			tc.setup(fsys)
+			before, _ := fsys.ReadFile("/runner/output")
+			files := memCommandFiles(fsys, map[string]string{EnvFileVariable: "/runner/env", OutputFileVariable: "/runner/output"})
+
+			batch := newExportBatch()
+			batch.add(EnvFileVariable, "FIRST", "one")
+			batch.add(OutputFileVariable, "SECOND", "two")
+			err := batch.commit(files)
+			is.True(errors.Is(err, tc.want)) // Commit should fail with the cause.
+
+			got, err := fsys.ReadFile("/runner/env")
+			is.NoErr(err)                         // Should read env file.
+			is.Equal(string(got), "EXISTING=1\n") // Env file should be rolled back.
+			if before != nil {
+				got, err = fsys.ReadFile("/runner/output")
+				is.NoErr(err)                         // Should read output file.
+				is.Equal(string(got), string(before)) // Output file should be rolled back.
+			}
+		})
+	}
+}
+
+func TestValidateOutputVariables(t *testing.T) {
+	pterm.DisableOutput()
+	cases := []struct {
+		name    string
+		items   []SecretToRetrieve
+		wantErr bool
+	}{
+		{
+			name: "valid names",
+			items: []SecretToRetrieve{
+				{SecretPath: "a", OutputVariable: "RETURN_VALUE_1"},
+				{SecretPath: "a", OutputVariable: "_value2"},
+			},
+			wantErr: false,
+		},
+		{
+			name:    "empty name",
+			items:   []SecretToRetrieve{{SecretPath: "a", OutputVariable: ""}},
+			wantErr: true,
+		},
+		{
+			name:    "name with equals sign",
+			items:   []SecretToRetrieve{{SecretPath: "a", OutputVariable: "FOO=BAR"}},
+			wantErr: true,
+		},
+		{
+			name: "duplicate names ignoring case",
+			items: []SecretToRetrieve{
+				{SecretPath: "a", OutputVariable: "value"},
+				{SecretPath: "b", OutputVariable: "VALUE"},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			is := is.New(t)
+			err := validateOutputVariables(tc.items)
+			is.Equal(tc.wantErr, err != nil) // Validation result should match.
+		})
+	}
+}
+
+func TestFormatCommandFileEntry(t *testing.T) {
+	is := is.New(t)
+
+	got, err := formatCommandFileEntry("key", "value")
+	is.NoErr(err)                // Single line value should format.
+	is.Equal(got, "KEY=value\n") // Single line value should use the short form.
+
+	got, err = formatCommandFileEntry("key", "line1\nINJECTED=1")
+	is.NoErr(err) // Multiline value should format.
+	lines := strings.Split(strings.TrimSuffix(got, "\n"), "\n")
+	is.Equal(len(lines), 4)                                    // Multiline value should be wrapped in a delimiter.
+	is.True(strings.HasPrefix(lines[0], "KEY<<ghadelimiter_")) // First line should open the delimiter.
+	is.Equal(lines[0], "KEY<<"+lines[3])                       // Last line should close the same delimiter.
+	is.Equal(lines[1:3], []string{"line1", "INJECTED=1"})      // Value should be kept intact between the delimiters.
+
+	_, err = formatCommandFileEntry("key", "line1\r\nline2")
+	is.True(err != nil) // CRLF would be changed to LF by the runner, so it should be refused.
+	_, err = formatCommandFileEntry("key", "line1\r")
+	is.True(err != nil) // A trailing CR would be read as part of a CRLF, so it should be refused.
+	_, err = formatCommandFileEntry("key", "line1\rline2")
+	is.NoErr(err) // A CR that isn't a line break should be kept.
+}
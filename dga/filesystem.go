@@ -0,0 +1,44 @@
+package dga
+
+import (
+	"io"
+	"io/fs"
+	"os"
+)
+
+// FileSystem is the files a run reads and writes: the retrieve file, the event payload and the workflow command files.
+type FileSystem interface {
+	ReadFile(name string) ([]byte, error)
+	Stat(name string) (fs.FileInfo, error)
+	Lstat(name string) (fs.FileInfo, error)
+	OpenFile(name string, flag int, perm fs.FileMode) (File, error)
+}
+
+// File is a file opened for writing, and is satisfied by *os.File.
+type File interface {
+	io.WriteCloser
+	Name() string
+	Stat() (fs.FileInfo, error)
+	Truncate(size int64) error
+}
+
+// OS is the FileSystem of the machine the action runs on.
+//
+//nolint:gochecknoglobals // stateless, the same as os.Stdout.
+var OS FileSystem = osFS{}
+
+// osFS calls the functions of the os package.
+type osFS struct{}
+
+func (osFS) ReadFile(name string) ([]byte, error)   { return os.ReadFile(name) }
+func (osFS) Stat(name string) (fs.FileInfo, error)  { return os.Stat(name) }
+func (osFS) Lstat(name string) (fs.FileInfo, error) { return os.Lstat(name) }
+
+func (osFS) OpenFile(name string, flag int, perm fs.FileMode) (File, error) {
+	f, err := os.OpenFile(name, flag, perm)
+	if err != nil {
+		// Return a nil interface rather than a nil *os.File, so callers can compare the File to nil.
+		return nil, err
+	}
+	return f, nil
+}
@@ -0,0 +1,106 @@
+package dga
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/DelineaXPM/dsv-github-action/dga/dsvtest"
+	"github.com/matryer/is"
+	"github.com/pterm/pterm"
+)
+
+// fixtureDir holds DSV responses to replay, which are synthetic until they're recorded with mage test:refreshFixtures.
+const fixtureDir = "testdata/fixtures"
+
+// fixtureSecretPath is the secret the fixtures read, the same one the integration workflow reads.
+const fixtureSecretPath = "ci:tests:dsv-github-action:secret-01"
+
+// fixtureConfig returns the config and HTTP client for a fixture.
+// With DSV_RECORD_FIXTURES=true the requests go to the tenant in DSV_DOMAIN, and the fixture is saved when the test passes.
+// Otherwise the fixture is replayed.
+func fixtureConfig(t *testing.T, name string) (*Config, HTTPClient) {
+	t.Helper()
+	file := filepath.Join(fixtureDir, name+".json")
+	if os.Getenv("DSV_RECORD_FIXTURES") != "true" {
+		f, err := dsvtest.LoadFixture(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &Config{DomainEnv: "example.secretsvaultcloud.com", ClientIDEnv: dsvtest.PlaceholderClientID, ClientSecretEnv: dsvtest.PlaceholderClientSecret}, dsvtest.NewReplayer(f)
+	}
+
+	cfg := &Config{DomainEnv: os.Getenv("DSV_DOMAIN"), ClientIDEnv: os.Getenv("DSV_CLIENT_ID"), ClientSecretEnv: os.Getenv("DSV_CLIENT_SECRET")}
+	if cfg.DomainEnv == "" || cfg.ClientIDEnv == "" || cfg.ClientSecretEnv == "" {
+		t.Fatal("DSV_DOMAIN, DSV_CLIENT_ID and DSV_CLIENT_SECRET are required to record fixtures")
+	}
+	rec := dsvtest.NewRecorder(&http.Client{Timeout: defaultTimeout})
+	t.Cleanup(func() {
+		if t.Failed() {
+			return
+		}
+		if err := rec.Save(file); err != nil {
+			t.Error(err)
+		}
+	})
+	return cfg, rec
+}
+
+// TestFixtureGetSecret checks the shape of a token and secret response from DSV still decodes.
+func TestFixtureGetSecret(t *testing.T) {
+	pterm.DisableOutput()
+	is := is.New(t)
+	cfg, c := fixtureConfig(t, "get-secret")
+	apiEndpoint := "https://" + cfg.DomainEnv + "/v1"
+
+	token, err := DSVGetToken(c, apiEndpoint, cfg)
+	is.NoErr(err)        // Token should be read from the response.
+	is.True(token != "") // Token should not be empty.
+
+	secret, err := getSecret(c, apiEndpoint, token, fixtureSecretPath, "", cfg)
+	is.NoErr(err)                            // Secret should decode.
+	is.Equal(secret.Path, fixtureSecretPath) // Path should be in the response.
+	is.True(secret.Data["value1"] != nil)    // Data should have the keys the integration workflow reads.
+	is.True(secret.Data["value2"] != nil)    // Data should have the keys the integration workflow reads.
+	is.True(secret.Version != "")            // Version should decode.
+	md := metadataFromSecret(secret)
+	is.True(md.Created != "" && md.LastModified != "") // Dates should decode.
+}
+
+// TestFixtureSecretNotFound checks a missing secret is still reported as not found.
+func TestFixtureSecretNotFound(t *testing.T) {
+	pterm.DisableOutput()
+	is := is.New(t)
+	cfg, c := fixtureConfig(t, "secret-not-found")
+	apiEndpoint := "https://" + cfg.DomainEnv + "/v1"
+
+	token, err := DSVGetToken(c, apiEndpoint, cfg)
+	is.NoErr(err) // Token should be read from the response.
+
+	_, err = getSecret(c, apiEndpoint, token, fixtureSecretPath+"-does-not-exist", "", cfg)
+	is.Equal(classify(err, KindUnknown), KindNotFound) // Missing secret should be classified as not found.
+}
+
+// TestFixturesHaveNoSecrets fails when a fixture has a value that looks like a real secret, such as one recorded before sanitizing was complete.
+func TestFixturesHaveNoSecrets(t *testing.T) {
+	names, err := filepath.Glob(filepath.Join(fixtureDir, "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) == 0 {
+		t.Fatalf("no fixtures found in %s", fixtureDir)
+	}
+	for _, name := range names {
+		t.Run(strings.TrimSuffix(filepath.Base(name), ".json"), func(t *testing.T) {
+			b, err := os.ReadFile(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := dsvtest.CheckFixture(b); err != nil {
+				t.Errorf("%s looks like it has a real secret: %v", name, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,229 @@
+package dga
+
+import (
+	"io/fs"
+	"os"
+	"path"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// MemFS is a FileSystem held in memory, for tests.
+// It checks the owner bits of a file's permissions the same way for every user, including root,
+// and can run out of space, so failures that are hard to cause on a real disk can be tested.
+type MemFS struct {
+	mu    sync.Mutex
+	files map[string]*memEntry
+	free  int64 // free is the number of bytes that can still be written, or -1 for no limit.
+}
+
+// memEntry is the content and permissions of a file in a MemFS.
+type memEntry struct {
+	data []byte
+	mode fs.FileMode
+}
+
+// Permission bits MemFS checks, for the owner of a file.
+const (
+	memOwnerRead  fs.FileMode = 0o400
+	memOwnerWrite fs.FileMode = 0o200
+)
+
+// NewMemFS returns an empty MemFS with no limit on space.
+func NewMemFS() *MemFS {
+	return &MemFS{files: make(map[string]*memEntry), free: -1}
+}
+
+// WriteFile creates or replaces the file with data and perm. It ignores permissions and free space, so a test can set up any file.
+func (m *MemFS) WriteFile(name string, data []byte, perm fs.FileMode) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.files[path.Clean(name)] = &memEntry{data: append([]byte(nil), data...), mode: perm.Perm()}
+}
+
+// Chmod changes the permissions of the file.
+func (m *MemFS) Chmod(name string, perm fs.FileMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.files[path.Clean(name)]
+	if !ok {
+		return &fs.PathError{Op: "chmod", Path: name, Err: fs.ErrNotExist}
+	}
+	e.mode = perm.Perm()
+	return nil
+}
+
+// SetFreeSpace limits how many more bytes can be written across every file.
+// A write that doesn't fit writes what does and fails with ENOSPC, the same as a full disk. A negative n removes the limit.
+func (m *MemFS) SetFreeSpace(n int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if n < 0 {
+		n = -1
+	}
+	m.free = n
+}
+
+// ReadFile returns the content of the file, if it's readable.
+func (m *MemFS) ReadFile(name string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.files[path.Clean(name)]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	if e.mode&memOwnerRead == 0 {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrPermission}
+	}
+	return append([]byte(nil), e.data...), nil
+}
+
+// Stat returns the size and permissions of the file.
+func (m *MemFS) Stat(name string) (fs.FileInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.files[path.Clean(name)]
+	if !ok {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+	}
+	return memFileInfo{name: path.Base(name), size: int64(len(e.data)), mode: e.mode}, nil
+}
+
+// Lstat is the same as Stat, as a MemFS has no links.
+func (m *MemFS) Lstat(name string) (fs.FileInfo, error) {
+	return m.Stat(name)
+}
+
+// OpenFile opens the file for writing, using the flags of os.OpenFile. Opening a file for reading isn't supported, use ReadFile.
+func (m *MemFS) OpenFile(name string, flag int, perm fs.FileMode) (File, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	name = path.Clean(name)
+	if flag&(os.O_WRONLY|os.O_RDWR) == 0 { //nolint:nosnakecase // standard package values.
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	e, ok := m.files[name]
+	switch {
+	case ok && flag&os.O_CREATE != 0 && flag&os.O_EXCL != 0: //nolint:nosnakecase // standard package values.
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrExist}
+	case ok && e.mode&memOwnerWrite == 0:
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrPermission}
+	case !ok && flag&os.O_CREATE == 0: //nolint:nosnakecase // standard package values.
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	case !ok:
+		e = &memEntry{mode: perm.Perm()}
+		m.files[name] = e
+	}
+	if flag&os.O_TRUNC != 0 { //nolint:nosnakecase // standard package values.
+		m.release(int64(len(e.data)))
+		e.data = nil
+	}
+	return &memFile{fs: m, name: name, entry: e, append: flag&os.O_APPEND != 0}, nil //nolint:nosnakecase // standard package values.
+}
+
+// release returns n bytes to the free space, when there's a limit. The caller holds m.mu.
+func (m *MemFS) release(n int64) {
+	if m.free >= 0 {
+		m.free += n
+	}
+}
+
+// memFile is a file opened for writing in a MemFS.
+type memFile struct {
+	fs     *MemFS
+	name   string
+	entry  *memEntry
+	append bool
+	offset int64
+	closed bool
+}
+
+func (f *memFile) Name() string { return f.name }
+
+// Write writes p at the offset, or at the end when the file was opened with O_APPEND, up to the free space.
+func (f *memFile) Write(p []byte) (int, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if f.closed {
+		return 0, &fs.PathError{Op: "write", Path: f.name, Err: fs.ErrClosed}
+	}
+	if f.append {
+		f.offset = int64(len(f.entry.data))
+	}
+
+	n := len(p)
+	var err error
+	if f.fs.free >= 0 {
+		grow := f.offset + int64(n) - int64(len(f.entry.data))
+		if grow > f.fs.free {
+			n -= int(grow - f.fs.free)
+			if n < 0 {
+				n = 0
+			}
+			err = &fs.PathError{Op: "write", Path: f.name, Err: syscall.ENOSPC}
+		}
+	}
+
+	end := f.offset + int64(n)
+	if grow := end - int64(len(f.entry.data)); grow > 0 {
+		f.entry.data = append(f.entry.data, make([]byte, grow)...)
+		f.fs.release(-grow)
+	}
+	copy(f.entry.data[f.offset:end], p[:n])
+	f.offset = end
+	return n, err
+}
+
+func (f *memFile) Stat() (fs.FileInfo, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	return memFileInfo{name: path.Base(f.name), size: int64(len(f.entry.data)), mode: f.entry.mode}, nil
+}
+
+// Truncate changes the size of the file, freeing the space of anything cut off.
+func (f *memFile) Truncate(size int64) error {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if f.closed {
+		return &fs.PathError{Op: "truncate", Path: f.name, Err: fs.ErrClosed}
+	}
+	if size < 0 {
+		return &fs.PathError{Op: "truncate", Path: f.name, Err: fs.ErrInvalid}
+	}
+	if grow := size - int64(len(f.entry.data)); grow > 0 {
+		if f.fs.free >= 0 && grow > f.fs.free {
+			return &fs.PathError{Op: "truncate", Path: f.name, Err: syscall.ENOSPC}
+		}
+		f.entry.data = append(f.entry.data, make([]byte, grow)...)
+		f.fs.release(-grow)
+		return nil
+	}
+	f.fs.release(int64(len(f.entry.data)) - size)
+	f.entry.data = f.entry.data[:size]
+	return nil
+}
+
+func (f *memFile) Close() error {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if f.closed {
+		return &fs.PathError{Op: "close", Path: f.name, Err: fs.ErrClosed}
+	}
+	f.closed = true
+	return nil
+}
+
+// memFileInfo describes a file in a MemFS.
+type memFileInfo struct {
+	name string
+	size int64
+	mode fs.FileMode
+}
+
+func (fi memFileInfo) Name() string       { return fi.name }
+func (fi memFileInfo) Size() int64        { return fi.size }
+func (fi memFileInfo) Mode() fs.FileMode  { return fi.mode }
+func (fi memFileInfo) ModTime() time.Time { return time.Time{} }
+func (fi memFileInfo) IsDir() bool        { return false }
+func (fi memFileInfo) Sys() any           { return nil }
@@ -0,0 +1,95 @@
+package dga
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/matryer/is"
+	"github.com/pterm/pterm"
+)
+
+func TestMemFS(t *testing.T) {
+	is := is.New(t)
+	fsys := NewMemFS()
+	fsys.WriteFile("/runner/env", []byte("A=1\n"), PermissionReadWriteOwner)
+
+	f, err := fsys.OpenFile("/runner/env", os.O_APPEND|os.O_WRONLY, PermissionReadWriteOwner) //nolint:nosnakecase // standard package values.
+	is.NoErr(err)                                                                             // Should open an existing file.
+	_, err = io.WriteString(f, "B=2\n")
+	is.NoErr(err) // Should append.
+	fi, err := f.Stat()
+	is.NoErr(err)                 // Should stat the open file.
+	is.Equal(fi.Size(), int64(8)) // Size should include the write.
+	is.NoErr(f.Truncate(4))       // Should truncate.
+	is.NoErr(f.Close())           // Should close.
+	_, err = io.WriteString(f, "C=3\n")
+	is.True(errors.Is(err, fs.ErrClosed)) // Writing a closed file should fail.
+	got, err := fsys.ReadFile("/runner/env")
+	is.NoErr(err)                  // Should read the file.
+	is.Equal(string(got), "A=1\n") // Content should be truncated.
+
+	_, err = fsys.OpenFile("/runner/missing", os.O_WRONLY, PermissionReadWriteOwner) //nolint:nosnakecase // standard package values.
+	is.True(errors.Is(err, fs.ErrNotExist))                                          // A missing file shouldn't be created without O_CREATE.
+	is.NoErr(fsys.Chmod("/runner/env", 0o400))                                       // Should make the file read only.
+	_, err = fsys.OpenFile("/runner/env", os.O_WRONLY, PermissionReadWriteOwner)     //nolint:nosnakecase // standard package values.
+	is.True(errors.Is(err, fs.ErrPermission))                                        // A read only file shouldn't open for writing, even as root.
+	is.NoErr(fsys.Chmod("/runner/env", 0o200))                                       // Should make the file write only.
+	_, err = fsys.ReadFile("/runner/env")
+	is.True(errors.Is(err, fs.ErrPermission)) // A write only file shouldn't be readable, even as root.
+
+	f, err = fsys.OpenFile("/runner/new", os.O_APPEND|os.O_CREATE|os.O_WRONLY, PermissionReadWriteOwner) //nolint:nosnakecase // standard package values.
+	is.NoErr(err)                                                                                        // Should create the file.
+	fsys.SetFreeSpace(3)
+	n, err := io.WriteString(f, "hello")
+	is.True(errors.Is(err, syscall.ENOSPC)) // A write that doesn't fit should fail as a full disk.
+	is.Equal(n, 3)                          // What fits should be written.
+	is.NoErr(f.Truncate(1))                 // Truncating should free space.
+	_, err = io.WriteString(f, "ab")
+	is.NoErr(err) // Freed space should be usable.
+}
+
+func TestOpenEnvFile(t *testing.T) {
+	pterm.DisableOutput()
+	cases := []struct {
+		name    string
+		envFile string // envFile is the path in GITHUB_ENV.
+		mode    fs.FileMode
+		wantErr error
+	}{
+		{name: "GITHUB_ENV not set"},
+		{name: "file missing", envFile: "/runner/missing", wantErr: fs.ErrNotExist},
+		{name: "file not writable", envFile: "/runner/env", mode: 0o400, wantErr: fs.ErrPermission},
+		{name: "file writable", envFile: "/runner/env", mode: PermissionReadWriteOwner},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			is := is.New(t)
+			fsys := NewMemFS()
+			fsys.WriteFile("/runner/env", []byte("foo=bar\n"), tc.mode)
+			t.Setenv(EnvFileVariable, tc.envFile)
+			if tc.envFile == "" {
+				os.Unsetenv(EnvFileVariable)
+			}
+
+			f, err := ActionsOpenEnvFile(&Config{IsCI: true, fs: fsys})
+			switch {
+			case tc.envFile == "":
+				is.True(err != nil) // Should fail without GITHUB_ENV.
+			case tc.wantErr != nil:
+				is.True(errors.Is(err, tc.wantErr)) // Should fail with the cause.
+			default:
+				is.NoErr(err)                                                         // Should open the env file.
+				is.NoErr(ActionExportVariable(f, "key", "val"))                       // Should write to the env file.
+				is.True(ActionExportVariable(f, "other", "val\r\nINJECTED=1") != nil) // A value GitHub would change should be refused.
+				is.NoErr(f.Close())                                                   // Should close the env file.
+				got, err := fsys.ReadFile("/runner/env")
+				is.NoErr(err)                               // Should read the env file.
+				is.Equal(string(got), "foo=bar\nKEY=val\n") // Variable should be appended.
+			}
+		})
+	}
+}
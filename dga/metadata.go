@@ -0,0 +1,46 @@
+package dga
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/DelineaXPM/dsv-github-action/dga/dsv"
+)
+
+// OutputFileVariable is the environment variable GitHub uses to point at the file that sets step outputs.
+const OutputFileVariable = "GITHUB_OUTPUT"
+
+// SecretMetadata is the part of a DSV secret that describes it, rather than the data it holds.
+type SecretMetadata struct {
+	Version      string
+	Created      string
+	LastModified string
+	Attributes   string // Attributes is JSON encoded, as DSV stores them as an object.
+	Description  string
+}
+
+// metadataFromSecret reads the metadata fields from a DSV secret.
+func metadataFromSecret(secret *dsv.Secret) SecretMetadata {
+	md := SecretMetadata{
+		Version:      string(secret.Version),
+		Created:      secret.Created,
+		LastModified: secret.LastModified,
+		Description:  secret.Description,
+	}
+	if secret.Attributes != nil {
+		if b, err := json.Marshal(secret.Attributes); err == nil {
+			md.Attributes = string(b)
+		}
+	}
+	return md
+}
+
+// addMetadataOutputs queues the metadata as step outputs named after the item's output variable, such as RETURN_VALUE_1_VERSION.
+func addMetadataOutputs(batch *exportBatch, outputVariable string, md SecretMetadata) {
+	prefix := strings.ToUpper(outputVariable) + "_"
+	batch.add(OutputFileVariable, prefix+"VERSION", md.Version)
+	batch.add(OutputFileVariable, prefix+"CREATED", md.Created)
+	batch.add(OutputFileVariable, prefix+"LAST_MODIFIED", md.LastModified)
+	batch.add(OutputFileVariable, prefix+"ATTRIBUTES", md.Attributes)
+	batch.add(OutputFileVariable, prefix+"DESCRIPTION", md.Description)
+}
@@ -0,0 +1,46 @@
+package dga
+
+import (
+	"testing"
+
+	"github.com/DelineaXPM/dsv-github-action/dga/dsv"
+	"github.com/matryer/is"
+)
+
+func TestMetadataFromSecret(t *testing.T) {
+	is := is.New(t)
+	md := metadataFromSecret(&dsv.Secret{
+		Version:      "3",
+		Created:      "2024-01-02T03:04:05Z",
+		LastModified: "2024-02-03T04:05:06Z",
+		Description:  "db credentials",
+		Attributes:   map[string]any{"ttl": "1h"},
+		Data:         map[string]any{"password": "hunter2"},
+	})
+	is.Equal(md, SecretMetadata{
+		Version:      "3",
+		Created:      "2024-01-02T03:04:05Z",
+		LastModified: "2024-02-03T04:05:06Z",
+		Attributes:   `{"ttl":"1h"}`,
+		Description:  "db credentials",
+	}) // Metadata should be read without any of the secret data.
+}
+
+func TestAddMetadataOutputs(t *testing.T) {
+	is := is.New(t)
+	batch := newExportBatch()
+	addMetadataOutputs(batch, "db_pass", SecretMetadata{Version: "3"})
+	is.Equal(batch.targets, []string{OutputFileVariable}) // Metadata should only be written as step outputs.
+
+	keys := make([]string, 0, len(batch.entries[OutputFileVariable]))
+	for _, e := range batch.entries[OutputFileVariable] {
+		keys = append(keys, e.key)
+	}
+	is.Equal(keys, []string{
+		"DB_PASS_VERSION",
+		"DB_PASS_CREATED",
+		"DB_PASS_LAST_MODIFIED",
+		"DB_PASS_ATTRIBUTES",
+		"DB_PASS_DESCRIPTION",
+	}) // Output names should be prefixed by the output variable.
+}
@@ -0,0 +1,111 @@
+package dga
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/pterm/pterm"
+)
+
+var (
+	// placeholderPattern matches ${NAME} in a secret path.
+	placeholderPattern = regexp.MustCompile(`\$\{([^}]*)\}`)
+	// placeholderNamePattern is the set of environment variable names a placeholder may use.
+	placeholderNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	// unsafeSegmentChars matches everything that isn't kept as is when a placeholder value is substituted.
+	unsafeSegmentChars = regexp.MustCompile(`[^A-Za-z0-9_.-]`)
+	// segmentHasContent is true for a substituted value that is more than punctuation, so ".." can't be used to walk up a path.
+	segmentHasContent = regexp.MustCompile(`[A-Za-z0-9]`)
+)
+
+// lookupFunc reads an environment variable, matching os.LookupEnv.
+type lookupFunc func(key string) (string, bool)
+
+// resolvePlaceholders replaces ${NAME} in each item's secretPath, and the secretPath of its fallbacks, with the value of the environment variable NAME.
+// Values are sanitized to a single path segment, so a branch name like "feature/../../prod" becomes "feature-..-..-prod" rather than changing the path.
+func resolvePlaceholders(items []SecretToRetrieve, lookup lookupFunc) ([]SecretToRetrieve, error) {
+	resolved := make([]SecretToRetrieve, len(items))
+	var errs []error
+	for i, item := range items {
+		path, err := expandPlaceholders(item.SecretPath, lookup)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%q: %w", item.SecretPath, err))
+		}
+		item.SecretPath = path
+		if len(item.Fallback) > 0 {
+			item.Fallback = append([]SecretCandidate(nil), item.Fallback...)
+			for j, candidate := range item.Fallback {
+				path, err := expandPlaceholders(candidate.SecretPath, lookup)
+				if err != nil {
+					errs = append(errs, fmt.Errorf("%q: %w", candidate.SecretPath, err))
+				}
+				item.Fallback[j].SecretPath = path
+			}
+		}
+		resolved[i] = item
+	}
+	return resolved, errors.Join(errs...)
+}
+
+// expandPlaceholders substitutes every placeholder in s.
+func expandPlaceholders(s string, lookup lookupFunc) (string, error) {
+	var errs []error
+	expanded := placeholderPattern.ReplaceAllStringFunc(s, func(match string) string {
+		name := match[2 : len(match)-1]
+		val, err := placeholderValue(name, lookup)
+		if err != nil {
+			errs = append(errs, err)
+			return match
+		}
+		return val
+	})
+	if len(errs) == 0 && strings.Contains(expanded, "${") {
+		errs = append(errs, fmt.Errorf("unterminated placeholder"))
+	}
+	return expanded, errors.Join(errs...)
+}
+
+// placeholderValue reads and sanitizes the value for a single placeholder.
+func placeholderValue(name string, lookup lookupFunc) (string, error) {
+	if !placeholderNamePattern.MatchString(name) {
+		return "", fmt.Errorf("placeholder ${%s} is not a valid environment variable name", name)
+	}
+	// Credentials shouldn't end up in a path, where they'd be sent to DSV and written to logs.
+	upper := strings.ToUpper(name)
+	if strings.HasPrefix(upper, "DSV_") || strings.Contains(upper, "SECRET") || strings.Contains(upper, "TOKEN") || strings.Contains(upper, "PASSWORD") {
+		return "", fmt.Errorf("placeholder ${%s} can't be used in a path", name)
+	}
+	val, ok := lookup(name)
+	if !ok {
+		return "", fmt.Errorf("placeholder ${%s} is not set", name)
+	}
+	sanitized := unsafeSegmentChars.ReplaceAllString(val, "-")
+	if !segmentHasContent.MatchString(sanitized) {
+		return "", fmt.Errorf("placeholder ${%s} resolves to %q, which isn't a usable path segment", name, val)
+	}
+	if sanitized != val {
+		pterm.Warning.Printfln("placeholder ${%s} value %q was sanitized to %q", name, val, sanitized)
+	}
+	return sanitized, nil
+}
+
+// loadRetrieve returns the retrieve configuration from DSV_RETRIEVE or the file named by DSV_RETRIEVE_FILE.
+func loadRetrieve(cfg *Config) (string, error) {
+	switch {
+	case cfg.RetrieveEnv != "" && cfg.RetrieveFileEnv != "":
+		return "", fmt.Errorf("DSV_RETRIEVE and DSV_RETRIEVE_FILE can't both be set")
+	case cfg.RetrieveFileEnv != "":
+		b, err := cfg.fileSystem().ReadFile(cfg.RetrieveFileEnv)
+		if err != nil {
+			return "", fmt.Errorf("unable to read DSV_RETRIEVE_FILE: %w", err)
+		}
+		pterm.Success.Printfln("read retrieve configuration from %s", cfg.RetrieveFileEnv)
+		return string(b), nil
+	case cfg.RetrieveEnv != "":
+		return cfg.RetrieveEnv, nil
+	default:
+		return "", fmt.Errorf("one of DSV_RETRIEVE or DSV_RETRIEVE_FILE is required")
+	}
+}
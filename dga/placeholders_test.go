@@ -0,0 +1,91 @@
+package dga
+
+import (
+	"errors"
+	"io/fs"
+	"testing"
+
+	"github.com/matryer/is"
+	"github.com/pterm/pterm"
+)
+
+func TestExpandPlaceholders(t *testing.T) {
+	pterm.DisableOutput()
+	vars := map[string]string{
+		"GITHUB_REF_NAME":   "feature/login",
+		"ENVIRONMENT":       "prod",
+		"GITHUB_REPOSITORY": "DelineaXPM/dsv-github-action",
+		"TRAVERSAL":         "..",
+		"SNEAKY":            "../../shared",
+		"COLON":             "a:b",
+		"DSV_CLIENT_SECRET": "s3cr3t",
+	}
+	lookup := func(key string) (string, bool) {
+		val, ok := vars[key]
+		return val, ok
+	}
+	cases := []struct {
+		name    string
+		path    string
+		want    string
+		wantErr bool
+	}{
+		{name: "no placeholders", path: "ci:app:prod", want: "ci:app:prod"},
+		{name: "environment", path: "ci:app:${ENVIRONMENT}:db", want: "ci:app:prod:db"},
+		{name: "branch with slash", path: "ci:app:${GITHUB_REF_NAME}", want: "ci:app:feature-login"},
+		{name: "repository", path: "ci:${GITHUB_REPOSITORY}:db", want: "ci:DelineaXPM-dsv-github-action:db"},
+		{name: "separator in value", path: "ci:${COLON}", want: "ci:a-b"},
+		{name: "traversal inside a value is flattened", path: "ci:app:${SNEAKY}", want: "ci:app:..-..-shared"},
+		{name: "dots only", path: "ci:app:${TRAVERSAL}:db", wantErr: true},
+		{name: "unset", path: "ci:${MISSING}", wantErr: true},
+		{name: "credential", path: "ci:${DSV_CLIENT_SECRET}", wantErr: true},
+		{name: "invalid name", path: "ci:${not valid}", wantErr: true},
+		{name: "unterminated", path: "ci:${ENVIRONMENT", wantErr: true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			is := is.New(t)
+			got, err := expandPlaceholders(tc.path, lookup)
+			if tc.wantErr {
+				is.True(err != nil) // Should fail.
+				return
+			}
+			is.NoErr(err)          // Should expand.
+			is.Equal(got, tc.want) // Expanded path should match.
+		})
+	}
+}
+
+func TestLoadRetrieve(t *testing.T) {
+	pterm.DisableOutput()
+	is := is.New(t)
+	const file = "/workspace/retrieve.json"
+	fsys := NewMemFS()
+	fsys.WriteFile(file, []byte(`[{"secretPath":"ci:${ENVIRONMENT}"}]`), PermissionReadWriteOwner)
+
+	got, err := loadRetrieve(&Config{RetrieveFileEnv: file, fs: fsys})
+	is.NoErr(err)                                         // Should read the file.
+	is.Equal(got, `[{"secretPath":"ci:${ENVIRONMENT}"}]`) // Should return the file content.
+
+	got, err = loadRetrieve(&Config{RetrieveEnv: "[]"})
+	is.NoErr(err)       // Should use DSV_RETRIEVE.
+	is.Equal(got, "[]") // Should return DSV_RETRIEVE.
+
+	_, err = loadRetrieve(&Config{RetrieveEnv: "[]", RetrieveFileEnv: file})
+	is.True(err != nil) // Both set should fail.
+	_, err = loadRetrieve(&Config{})
+	is.True(err != nil) // Neither set should fail.
+
+	is.NoErr(fsys.Chmod(file, 0o200)) // Should make the file unreadable.
+	_, err = loadRetrieve(&Config{RetrieveFileEnv: file, fs: fsys})
+	is.True(errors.Is(err, fs.ErrPermission)) // Unreadable file should fail with the cause.
+}
+
+func TestResolvedPathsSummary(t *testing.T) {
+	is := is.New(t)
+	got := resolvedPathsSummary(
+		[]SecretToRetrieve{{SecretPath: "ci:${ENVIRONMENT}:db"}},
+		[]SecretToRetrieve{{SecretPath: "ci:prod:db"}},
+	)
+	is.Equal(got, "### DSV Secret Paths\n\n| Configured | Resolved |\n| --- | --- |\n| `ci:${ENVIRONMENT}:db` | `ci:prod:db` |\n\n") // Summary should list both paths.
+}
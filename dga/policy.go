@@ -0,0 +1,267 @@
+package dga
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/DelineaXPM/dsv-github-action/dga/dsv"
+	"github.com/pterm/pterm"
+)
+
+// Policies for a run the policy doesn't trust, set with "untrusted".
+const (
+	UntrustedDeny      = "deny"      // UntrustedDeny refuses to retrieve anything, and is used when untrusted is empty.
+	UntrustedAllowList = "allowList" // UntrustedAllowList only retrieves secrets under a path in allowList.
+)
+
+// ErrNotAllowed is wrapped by errors for a secret the policy doesn't let this run read.
+var ErrNotAllowed = errors.New("not allowed by policy")
+
+// Policy decides which runs can retrieve secrets.
+// A run is untrusted when it's for a pull request from a fork, or when its ref doesn't match allowedRefs.
+//
+//nolint:tagliatelle // Here 'camel' casing is used instead of 'kebab'.
+type Policy struct {
+	// AllowedRefs are patterns, such as "refs/heads/main" or "refs/tags/v*", that GITHUB_REF must match. Any ref is allowed when empty.
+	AllowedRefs []string `json:"allowedRefs,omitempty"`
+	// Untrusted is what an untrusted run can do: deny (the default) or allowList.
+	Untrusted string `json:"untrusted,omitempty"`
+	// AllowList are the secret paths an untrusted run can read when untrusted is allowList, including every secret under them.
+	// A segment can be a pattern, such as "ci:public:*".
+	AllowList []string `json:"allowList,omitempty"`
+	// AllowedPaths are the only secret paths any run can read, the same as the allowedPaths input.
+	AllowedPaths []string `json:"allowedPaths,omitempty"`
+}
+
+// runContext is what the policy knows about the workflow run.
+type runContext struct {
+	EventName      string
+	Ref            string
+	Repository     string
+	HeadRepository string // HeadRepository is the repository the pull request is from, empty for other events.
+	DefaultBranch  string
+	Fork           bool
+}
+
+// pullRequestEvents are the events whose payload has the pull request that triggered them.
+//
+//nolint:gochecknoglobals // read only set of event names.
+var pullRequestEvents = map[string]bool{
+	"pull_request":                true,
+	"pull_request_target":         true,
+	"pull_request_review":         true,
+	"pull_request_review_comment": true,
+}
+
+// eventPayload is the part of the GITHUB_EVENT_PATH payload the policy uses.
+//
+//nolint:tagliatelle // GitHub's payload uses snake case.
+type eventPayload struct {
+	Repository struct {
+		FullName      string `json:"full_name"`
+		DefaultBranch string `json:"default_branch"`
+	} `json:"repository"`
+	PullRequest *struct {
+		Head struct {
+			Repo *struct {
+				FullName string `json:"full_name"`
+			} `json:"repo"`
+		} `json:"head"`
+	} `json:"pull_request"`
+	WorkflowRun *struct {
+		HeadRepository *struct {
+			FullName string `json:"full_name"`
+		} `json:"head_repository"`
+	} `json:"workflow_run"`
+}
+
+// readRunContext builds the run context from the GitHub environment variables and event payload.
+// A pull request whose head repository can't be read, such as a deleted fork, is treated as a fork.
+func readRunContext(cfg *Config) (runContext, error) {
+	rc := runContext{EventName: cfg.EventName, Ref: cfg.Ref, Repository: cfg.Repository}
+	if cfg.EventPath == "" {
+		if pullRequestEvents[rc.EventName] || rc.EventName == "workflow_run" {
+			return rc, fmt.Errorf("GITHUB_EVENT_PATH is not set for a %s event", rc.EventName)
+		}
+		return rc, nil
+	}
+	b, err := cfg.fileSystem().ReadFile(cfg.EventPath)
+	if err != nil {
+		return rc, fmt.Errorf("unable to read GITHUB_EVENT_PATH: %w", err)
+	}
+	var payload eventPayload
+	if err := json.Unmarshal(b, &payload); err != nil {
+		return rc, fmt.Errorf("unable to parse GITHUB_EVENT_PATH: %w", err)
+	}
+	if rc.Repository == "" {
+		rc.Repository = payload.Repository.FullName
+	}
+	rc.DefaultBranch = payload.Repository.DefaultBranch
+
+	switch {
+	case pullRequestEvents[rc.EventName]:
+		if payload.PullRequest != nil && payload.PullRequest.Head.Repo != nil {
+			rc.HeadRepository = payload.PullRequest.Head.Repo.FullName
+		}
+		rc.Fork = !strings.EqualFold(rc.HeadRepository, rc.Repository)
+	case rc.EventName == "workflow_run":
+		// A workflow_run started by a pull request from a fork runs with secrets, on behalf of the fork.
+		if payload.WorkflowRun != nil && payload.WorkflowRun.HeadRepository != nil {
+			rc.HeadRepository = payload.WorkflowRun.HeadRepository.FullName
+		}
+		rc.Fork = !strings.EqualFold(rc.HeadRepository, rc.Repository)
+	}
+	return rc, nil
+}
+
+// validatePolicy checks the policy before it's used.
+func validatePolicy(p Policy) error {
+	var errs []error
+	switch p.Untrusted {
+	case "", UntrustedDeny, UntrustedAllowList:
+	default:
+		errs = append(errs, fmt.Errorf("untrusted %q is not supported, use %q or %q", p.Untrusted, UntrustedDeny, UntrustedAllowList))
+	}
+	for _, pattern := range p.AllowedRefs {
+		if _, err := path.Match(pattern, ""); err != nil {
+			errs = append(errs, fmt.Errorf("allowedRefs %q: %w", pattern, err))
+		}
+	}
+	errs = append(errs, validatePathPatterns("allowList", p.AllowList)...)
+	errs = append(errs, validatePathPatterns("allowedPaths", p.AllowedPaths)...)
+	return errors.Join(errs...)
+}
+
+// decide returns whether the run is limited to the allowList, or an error when the run can't retrieve anything.
+// Every decision is logged, including why.
+func (p Policy) decide(rc runContext) (bool, error) {
+	pterm.Info.Printfln("policy: event %q, ref %q, repository %q, head repository %q", rc.EventName, rc.Ref, rc.Repository, rc.HeadRepository)
+
+	var reason string
+	switch {
+	case rc.Fork:
+		reason = fmt.Sprintf("%s from fork %q", rc.EventName, rc.HeadRepository)
+	case len(p.AllowedRefs) > 0 && !matchAny(p.AllowedRefs, rc.Ref):
+		reason = fmt.Sprintf("ref %q doesn't match allowedRefs", rc.Ref)
+	default:
+		pterm.Success.Println("policy: run is trusted")
+		return false, nil
+	}
+
+	if p.Untrusted != UntrustedAllowList {
+		pterm.Warning.Printfln("policy: denied, %s", reason)
+		return false, fmt.Errorf("%s: %w", reason, ErrNotAllowed)
+	}
+	pterm.Warning.Printfln("policy: limited to allowList %s, %s", strings.Join(p.AllowList, ", "), reason)
+	return true, nil
+}
+
+// matchAny reports whether s matches any of the patterns.
+func matchAny(patterns []string, s string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, s); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// loadPolicy reads the policy file from the default branch through the GitHub API, so a pull request can't change it.
+// Without a policy file, pull requests from forks are denied and every other run is trusted.
+func loadPolicy(c HTTPClient, cfg *Config, rc runContext) (Policy, error) {
+	if cfg.PolicyFile == "" {
+		pterm.Info.Println("policy: DSV_POLICY_FILE is not set, pull requests from forks are denied")
+		return Policy{}, nil
+	}
+	if rc.DefaultBranch == "" || rc.Repository == "" {
+		return Policy{}, fmt.Errorf("the repository and default branch aren't known, so DSV_POLICY_FILE can't be read")
+	}
+	if cfg.GitHubToken == "" {
+		return Policy{}, fmt.Errorf("DSV_GITHUB_TOKEN is required to read DSV_POLICY_FILE")
+	}
+
+	endpoint, err := url.JoinPath(cfg.GitHubAPIURL, "repos", rc.Repository, "contents", strings.TrimPrefix(cfg.PolicyFile, "/"))
+	if err != nil {
+		return Policy{}, fmt.Errorf("unable to build url: %w", err)
+	}
+	endpoint += "?" + url.Values{"ref": {rc.DefaultBranch}}.Encode()
+	req, err := http.NewRequest(http.MethodGet, endpoint, nil)
+	if err != nil {
+		return Policy{}, fmt.Errorf("could not build request: %w", err)
+	}
+	req.Header.Set("Accept", "application/vnd.github.raw+json")
+	req.Header.Set("Authorization", "Bearer "+cfg.GitHubToken)
+
+	resp, err := c.Do(req)
+	if err != nil {
+		return Policy{}, fmt.Errorf("unable to read DSV_POLICY_FILE: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return Policy{}, fmt.Errorf("unable to read DSV_POLICY_FILE %q from %q: %w", cfg.PolicyFile, rc.DefaultBranch, dsv.NewAPIError(req, resp))
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return Policy{}, fmt.Errorf("could not read response body: %w", err)
+	}
+
+	var p Policy
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&p); err != nil {
+		return Policy{}, fmt.Errorf("unable to parse DSV_POLICY_FILE: %w", err)
+	}
+	if err := validatePolicy(p); err != nil {
+		return Policy{}, fmt.Errorf("invalid DSV_POLICY_FILE: %w", err)
+	}
+	pterm.Success.Printfln("policy: read %s from %s", cfg.PolicyFile, rc.DefaultBranch)
+	return p, nil
+}
+
+// applyPolicy decides what the run can retrieve, failing closed when the policy or run context can't be read.
+// Every path the items read is checked, so a denied item stops the run before it authenticates with DSV.
+func applyPolicy(c HTTPClient, cfg *Config, items []SecretToRetrieve) error {
+	var guard *pathGuard
+	if patterns := parsePathList(cfg.AllowedPathsEnv); len(patterns) > 0 {
+		if err := errors.Join(validatePathPatterns("DSV_ALLOWED_PATHS", patterns)...); err != nil {
+			return err
+		}
+		guard = guard.restrict("DSV_ALLOWED_PATHS", patterns)
+	}
+
+	if cfg.IsCI {
+		rc, err := readRunContext(cfg)
+		if err != nil {
+			return err
+		}
+		p, err := loadPolicy(c, cfg, rc)
+		if err != nil {
+			return err
+		}
+		if len(p.AllowedPaths) > 0 {
+			guard = guard.restrict("allowedPaths in "+cfg.PolicyFile, p.AllowedPaths)
+		}
+		limited, err := p.decide(rc)
+		if err != nil {
+			return err
+		}
+		if limited {
+			guard = guard.restrict("allowList in "+cfg.PolicyFile, p.AllowList)
+		}
+	} else {
+		pterm.Info.Println("policy: not running in GitHub Actions, policy file isn't applied")
+	}
+
+	if err := guard.checkItems(items); err != nil {
+		return err
+	}
+	cfg.guard = guard
+	return nil
+}
@@ -0,0 +1,152 @@
+package dga
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/matryer/is"
+	"github.com/pterm/pterm"
+)
+
+// eventFS returns a filesystem with the event payload at eventPath.
+func eventFS(payload string) *MemFS {
+	fsys := NewMemFS()
+	fsys.WriteFile(eventPath, []byte(payload), PermissionReadWriteOwner)
+	return fsys
+}
+
+// eventPath is where the runner would write the event payload.
+const eventPath = "/runner/event.json"
+
+func TestReadRunContext(t *testing.T) {
+	pterm.DisableOutput()
+	cases := []struct {
+		name     string
+		event    string
+		payload  string
+		wantFork bool
+		wantHead string
+	}{
+		{
+			name:    "push",
+			event:   "push",
+			payload: `{"repository":{"full_name":"org/repo","default_branch":"main"}}`,
+		},
+		{
+			name:     "pull request from the same repository",
+			event:    "pull_request",
+			payload:  `{"repository":{"full_name":"org/repo"},"pull_request":{"head":{"repo":{"full_name":"org/repo"}}}}`,
+			wantHead: "org/repo",
+		},
+		{
+			name:     "pull request target from a fork",
+			event:    "pull_request_target",
+			payload:  `{"repository":{"full_name":"org/repo"},"pull_request":{"head":{"repo":{"full_name":"someone/repo"}}}}`,
+			wantFork: true,
+			wantHead: "someone/repo",
+		},
+		{
+			name:     "pull request from a deleted fork",
+			event:    "pull_request_target",
+			payload:  `{"repository":{"full_name":"org/repo"},"pull_request":{"head":{"repo":null}}}`,
+			wantFork: true,
+		},
+		{
+			name:     "workflow run from a fork",
+			event:    "workflow_run",
+			payload:  `{"repository":{"full_name":"org/repo"},"workflow_run":{"head_repository":{"full_name":"someone/repo"}}}`,
+			wantFork: true,
+			wantHead: "someone/repo",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			is := is.New(t)
+			cfg := &Config{EventName: tc.event, EventPath: eventPath, Repository: "org/repo", fs: eventFS(tc.payload)}
+			rc, err := readRunContext(cfg)
+			is.NoErr(err)                            // Should read the run context.
+			is.Equal(rc.Fork, tc.wantFork)           // Fork should be detected.
+			is.Equal(rc.HeadRepository, tc.wantHead) // Head repository should be read from the payload.
+		})
+	}
+
+	is := is.New(t)
+	_, err := readRunContext(&Config{EventName: "pull_request_target"})
+	is.True(err != nil) // A pull request without a payload can't be trusted.
+}
+
+func TestPolicyDecide(t *testing.T) {
+	pterm.DisableOutput()
+	trusted := runContext{EventName: "push", Ref: "refs/heads/main", Repository: "org/repo"}
+	fork := runContext{EventName: "pull_request_target", Ref: "refs/heads/main", Repository: "org/repo", HeadRepository: "someone/repo", Fork: true}
+	cases := []struct {
+		name       string
+		policy     Policy
+		rc         runContext
+		wantDenied bool
+		wantLimit  bool
+	}{
+		{name: "no policy trusts a push", rc: trusted},
+		{name: "no policy denies a fork", rc: fork, wantDenied: true},
+		{name: "allow list limits a fork", policy: Policy{Untrusted: UntrustedAllowList, AllowList: []string{"ci:public"}}, rc: fork, wantLimit: true},
+		{name: "allowed ref", policy: Policy{AllowedRefs: []string{"refs/heads/main", "refs/tags/v*"}}, rc: trusted},
+		{
+			name:       "ref not allowed",
+			policy:     Policy{AllowedRefs: []string{"refs/tags/v*"}},
+			rc:         trusted,
+			wantDenied: true,
+		},
+		{
+			name:      "ref not allowed with allow list",
+			policy:    Policy{AllowedRefs: []string{"refs/tags/v*"}, Untrusted: UntrustedAllowList, AllowList: []string{"ci:public"}},
+			rc:        trusted,
+			wantLimit: true,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			is := is.New(t)
+			limited, err := tc.policy.decide(tc.rc)
+			is.Equal(errors.Is(err, ErrNotAllowed), tc.wantDenied) // Run should only be denied when untrusted.
+			is.Equal(limited, tc.wantLimit)                        // Run should only be limited by an allow list when untrusted.
+		})
+	}
+}
+
+func TestLoadPolicy(t *testing.T) {
+	pterm.DisableOutput()
+	is := is.New(t)
+	rc := runContext{Repository: "org/repo", DefaultBranch: "main"}
+	cfg := &Config{PolicyFile: ".github/dsv-policy.json", GitHubToken: "ghs_token", GitHubAPIURL: "https://api.github.com"}
+
+	rec := &policyRecorder{body: `{"allowedRefs":["refs/heads/main"],"untrusted":"allowList","allowList":["ci:public"]}`}
+	p, err := loadPolicy(rec, cfg, rc)
+	is.NoErr(err)                                                                  // Should read the policy.
+	is.Equal(p.Untrusted, UntrustedAllowList)                                      // Policy should be parsed.
+	is.Equal(rec.req.URL.Path, "/repos/org/repo/contents/.github/dsv-policy.json") // Policy should be read through the contents API.
+	is.Equal(rec.req.URL.Query().Get("ref"), "main")                               // Policy should be read from the default branch.
+	is.Equal(rec.req.Header.Get("Authorization"), "Bearer ghs_token")              // Request should use the token.
+
+	_, err = loadPolicy(&policyRecorder{body: `{"untrusted":"maybe"}`}, cfg, rc)
+	is.True(err != nil) // Unsupported untrusted should fail.
+	_, err = loadPolicy(&policyRecorder{body: `{"allowPaths":["ci"]}`}, cfg, rc)
+	is.True(err != nil) // Unknown fields should fail rather than be ignored.
+	_, err = loadPolicy(responder{status: http.StatusNotFound}, cfg, rc)
+	is.True(err != nil) // A missing policy file should fail closed.
+
+	p, err = loadPolicy(nil, &Config{}, rc)
+	is.NoErr(err)             // No policy file uses the default policy.
+	is.Equal(p.Untrusted, "") // Default policy denies untrusted runs.
+}
+
+// policyRecorder captures the last request and returns the body.
+type policyRecorder struct {
+	req  *http.Request
+	body string
+}
+
+func (r *policyRecorder) Do(req *http.Request) (*http.Response, error) {
+	r.req = req
+	return responder{status: http.StatusOK, body: r.body}.Do(req)
+}
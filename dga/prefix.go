@@ -0,0 +1,253 @@
+package dga
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/DelineaXPM/dsv-github-action/dga/dsv"
+	"github.com/pterm/pterm"
+)
+
+// Types of retrieve item, set with "type".
+const (
+	TypeSecret = "secret" // TypeSecret reads one key from one secret, and is used when type is empty.
+	TypePrefix = "prefix" // TypePrefix reads every secret under secretPath.
+)
+
+const (
+	// defaultMaxMatches is the number of secrets a prefix item may match when maxMatches isn't set.
+	defaultMaxMatches = 25
+	// searchPageSize is the number of secrets requested per page when searching DSV.
+	searchPageSize = 50
+	// defaultOutputTemplate names exported variables after the path relative to the prefix and the key.
+	defaultOutputTemplate = "{{.RelPath}}_{{.Key}}"
+)
+
+// invalidEnvNameChars matches everything that isn't allowed in an environment variable name.
+var invalidEnvNameChars = regexp.MustCompile(`[^A-Z0-9_]`)
+
+// outputNameData is what an outputTemplate can reference to name each variable exported by a prefix item.
+type outputNameData struct {
+	Prefix  string // Prefix is the secretPath of the item.
+	Path    string // Path is the full path of the matched secret.
+	RelPath string // RelPath is Path relative to Prefix, such as "db:primary".
+	Key     string // Key is the data key being exported.
+}
+
+// envName turns the output of a naming template into a valid environment variable name.
+// For example "db:primary_password" becomes "DB_PRIMARY_PASSWORD".
+func envName(s string) string {
+	name := invalidEnvNameChars.ReplaceAllString(strings.ToUpper(s), "_")
+	if name == "" || (name[0] >= '0' && name[0] <= '9') {
+		name = "_" + name
+	}
+	return name
+}
+
+// normalizePath uses colons between segments, as DSV accepts either colons or slashes in a path.
+func normalizePath(path string) string {
+	return strings.Trim(strings.ReplaceAll(path, "/", ":"), ":")
+}
+
+// validatePrefixItems checks the fields of prefix items, which are used differently to a single secret.
+func validatePrefixItems(items []SecretToRetrieve) []error {
+	var errs []error
+	for _, item := range items {
+		switch item.Type {
+		case "", TypeSecret, TypeCompose:
+			continue
+		case TypePrefix:
+		default:
+			errs = append(errs, fmt.Errorf("%q: type %q is not supported, use %q, %q or %q", item.SecretPath, item.Type, TypeSecret, TypePrefix, TypeCompose))
+			continue
+		}
+		if normalizePath(item.SecretPath) == "" {
+			errs = append(errs, fmt.Errorf("a prefix item needs a secretPath"))
+		}
+		if item.Version != "" || item.Default != nil {
+			errs = append(errs, fmt.Errorf("%q: version and default can't be used with a prefix item", item.SecretPath))
+		}
+		if item.OutputVariable != "" {
+			errs = append(errs, fmt.Errorf("%q: a prefix item names its outputs with outputTemplate instead of outputVariable", item.SecretPath))
+		}
+		if item.MaxMatches < 0 {
+			errs = append(errs, fmt.Errorf("%q: maxMatches can't be negative", item.SecretPath))
+		}
+		if _, err := outputTemplate(item); err != nil {
+			errs = append(errs, fmt.Errorf("%q: invalid outputTemplate: %w", item.SecretPath, err))
+		}
+	}
+	return errs
+}
+
+// outputTemplate parses the naming template of a prefix item.
+func outputTemplate(item SecretToRetrieve) (*template.Template, error) {
+	text := item.OutputTemplate
+	if text == "" {
+		text = defaultOutputTemplate
+	}
+	return template.New("outputTemplate").Option("missingkey=error").Parse(text)
+}
+
+// resolvePrefix lists every secret under the prefix and resolves each of its keys, or just secretKey when it's set.
+// Each exported value gets its own result, named by the item's outputTemplate, with the latency of fetching its secret from now.
+func resolvePrefix(c HTTPClient, apiEndpoint, token string, item SecretToRetrieve, cfg *Config, now func() time.Time) []itemResult {
+	pterm.Debug.Printfln("start processing prefix: SecretPath: %s", item.SecretPath)
+	failed := func(err error) []itemResult {
+		return []itemResult{{Item: item, Status: StatusFailed, Err: err}}
+	}
+
+	maxMatches := item.MaxMatches
+	if maxMatches == 0 {
+		maxMatches = defaultMaxMatches
+	}
+	paths, err := DSVSearchSecrets(c, apiEndpoint, token, item.SecretPath, maxMatches, cfg)
+	if err != nil {
+		pterm.Error.Printfln("%q: Failed to search secrets: %v", item.SecretPath, err)
+		return failed(fmt.Errorf("%q: unable to search secrets: %w", item.SecretPath, err))
+	}
+	if len(paths) == 0 {
+		return failed(fmt.Errorf("%q: no secrets under prefix: %w", item.SecretPath, ErrNotFound))
+	}
+
+	tmpl, err := outputTemplate(item)
+	if err != nil {
+		return failed(fmt.Errorf("%q: invalid outputTemplate: %w", item.SecretPath, err))
+	}
+
+	prefix := normalizePath(item.SecretPath)
+	var results []itemResult
+	for _, path := range paths {
+		match := SecretToRetrieve{SecretPath: path, SecretKey: item.SecretKey}
+		start := now()
+		secret, err := getSecret(c, apiEndpoint, token, path, "", cfg)
+		latency := now().Sub(start)
+		if err != nil {
+			pterm.Error.Printfln("%q: Failed to fetch secret: %v", path, err)
+			results = append(results, itemResult{Item: match, Status: StatusFailed, Err: fmt.Errorf("%q: unable to get secret: %w", path, err), Latency: latency})
+			continue
+		}
+		secretData := secret.Data
+		metadata := metadataFromSecret(secret)
+
+		keys := []string{item.SecretKey}
+		if item.SecretKey == "" {
+			keys = sortedKeys(secretData)
+		}
+		for _, key := range keys {
+			data := outputNameData{
+				Prefix:  prefix,
+				Path:    path,
+				RelPath: strings.TrimPrefix(strings.TrimPrefix(path, prefix), ":"),
+				Key:     key,
+			}
+			var name strings.Builder
+			if err := tmpl.Execute(&name, data); err != nil {
+				results = append(results, itemResult{Item: match, Status: StatusFailed, Err: fmt.Errorf("%q: unable to name output for %q: %w", path, key, err), Latency: latency})
+				continue
+			}
+			exported := SecretToRetrieve{SecretPath: path, SecretKey: key, OutputVariable: envName(name.String()), Validate: item.Validate}
+
+			raw := secretData[key]
+			if len(item.Transforms) > 0 {
+				raw = structuredAsJSON(raw)
+			}
+			if _, isRef := parseRef(raw); isRef && cfg.FollowRefs {
+				followed, err := followRefs(c, apiEndpoint, token, SecretCandidate{SecretPath: path, SecretKey: key}, raw, cfg)
+				if err != nil {
+					results = append(results, itemResult{Item: exported, Status: StatusFailed, Err: err, Latency: latency})
+					continue
+				}
+				raw = followed
+			}
+			val, ok := raw.(string)
+			if !ok {
+				// Only string values are exported, so other keys of the secret are skipped when no key was requested.
+				if item.SecretKey == "" {
+					pterm.Debug.Printfln("%q: skipping non-string key %q", path, key)
+					continue
+				}
+				results = append(results, itemResult{Item: exported, Status: StatusFailed, Err: fmt.Errorf("%q: specified field %q: %w", path, key, ErrKeyMissing), Latency: latency})
+				continue
+			}
+			if val, err = applyTransforms(item.Transforms, val); err != nil {
+				results = append(results, itemResult{Item: exported, Status: StatusFailed, Err: fmt.Errorf("%q: %w", exported, err), Latency: latency})
+				continue
+			}
+			results = append(results, itemResult{
+				Item:     exported,
+				Status:   StatusRetrieved,
+				Value:    val,
+				Metadata: &metadata,
+				Used:     SecretCandidate{SecretPath: path, SecretKey: key},
+				Latency:  latency,
+			})
+		}
+	}
+	pterm.Success.Printfln("%q: resolved %d values from %d secrets", item.SecretPath, len(results), len(paths))
+	return results
+}
+
+// sortedKeys returns the keys of m in a stable order, so exports don't change between runs.
+func sortedKeys(m map[string]any) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// DSVSearchSecrets returns the path of every secret under the prefix, following pagination.
+// It fails rather than truncating when more than maxMatches secrets are found.
+func DSVSearchSecrets(
+	c HTTPClient,
+	apiEndpoint, accessToken, prefix string,
+	maxMatches int,
+	cfg *Config,
+) ([]string, error) {
+	pterm.Info.Println("DSVSearchSecrets()")
+	if err := cfg.guard.checkPrefix(prefix); err != nil {
+		return nil, err
+	}
+	prefix = normalizePath(prefix)
+	client := cfg.client(c, apiEndpoint)
+
+	var paths []string
+	cursor := ""
+	for {
+		page, err := client.SearchSecrets(context.Background(), accessToken, dsv.SearchRequest{
+			SearchText:  prefix,
+			SearchField: "path",
+			Limit:       searchPageSize,
+			Cursor:      cursor,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("API call failed: %w", err)
+		}
+
+		for _, s := range page.Data {
+			// Search matches anywhere in the path, so only keep secrets that are actually under the prefix.
+			path := normalizePath(s.Path)
+			if !strings.HasPrefix(path, prefix+":") {
+				continue
+			}
+			paths = append(paths, path)
+			if len(paths) > maxMatches {
+				return nil, fmt.Errorf("more than %d secrets match prefix %q, raise maxMatches if this is intended", maxMatches, prefix)
+			}
+		}
+		if page.Cursor == "" || page.Cursor == cursor || len(page.Data) == 0 {
+			break
+		}
+		cursor = page.Cursor
+	}
+	sort.Strings(paths)
+	pterm.Success.Printfln("DSVSearchSecrets() found %d secrets", len(paths))
+	return paths, nil
+}
@@ -0,0 +1,202 @@
+package dga
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+	"github.com/pterm/pterm"
+)
+
+// searchServer answers DSV search requests from pages of paths, secret reads from secrets, and any token request.
+type searchServer struct {
+	pages   [][]string
+	secrets map[string]map[string]any
+}
+
+func (s searchServer) Do(req *http.Request) (*http.Response, error) {
+	respond := func(status int, body any) (*http.Response, error) {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		return &http.Response{
+			Status:     fmt.Sprintf("%d %s", status, http.StatusText(status)),
+			StatusCode: status,
+			Body:       io.NopCloser(bytes.NewReader(b)),
+		}, nil
+	}
+
+	if req.URL.Path == "/v1/token" {
+		return respond(http.StatusOK, map[string]any{"accessToken": "token", "tokenType": "bearer", "expiresIn": 3600})
+	}
+	if req.URL.Path == "/v1/secrets" {
+		page := 0
+		fmt.Sscan(req.URL.Query().Get("cursor"), &page)
+		var data []map[string]string
+		for _, path := range s.pages[page] {
+			data = append(data, map[string]string{"path": path})
+		}
+		cursor := ""
+		if page+1 < len(s.pages) {
+			cursor = fmt.Sprint(page + 1)
+		}
+		return respond(http.StatusOK, map[string]any{"data": data, "cursor": cursor})
+	}
+
+	path := strings.TrimPrefix(req.URL.Path, "/v1/secrets/")
+	data, ok := s.secrets[path]
+	if !ok {
+		return respond(http.StatusNotFound, map[string]any{})
+	}
+	return respond(http.StatusOK, map[string]any{"path": path, "version": "1", "data": data})
+}
+
+func TestResolvePrefix(t *testing.T) {
+	pterm.DisableOutput()
+	server := searchServer{
+		pages: [][]string{
+			{"ci:services:payments:db", "ci:services:payments-old:db"},
+			{"ci:services:payments:api"},
+		},
+		secrets: map[string]map[string]any{
+			"ci:services:payments:db":     {"user": "app", "password": "hunter2", "port": float64(5432)},
+			"ci:services:payments:api":    {"token": "abc"},
+			"ci:services:payments-old:db": {"user": "old"},
+		},
+	}
+	cases := []struct {
+		name       string
+		item       SecretToRetrieve
+		allowed    []string // allowed are the allowed path patterns, which aren't restricted when empty.
+		wantValues map[string]string
+		wantErr    bool
+	}{
+		{
+			name: "every string key under the prefix",
+			item: SecretToRetrieve{Type: TypePrefix, SecretPath: "ci:services:payments"},
+			wantValues: map[string]string{
+				"API_TOKEN":   "abc",
+				"DB_PASSWORD": "hunter2",
+				"DB_USER":     "app",
+			},
+		},
+		{
+			name:       "single key with a custom template",
+			item:       SecretToRetrieve{Type: TypePrefix, SecretPath: "ci/services/payments/", SecretKey: "user", OutputTemplate: "PAYMENTS_{{.RelPath}}"},
+			wantValues: map[string]string{"PAYMENTS_DB": "app"},
+			wantErr:    true, // The api secret has no user key.
+		},
+		{
+			name:    "allowed by a pattern for the secrets under it",
+			item:    SecretToRetrieve{Type: TypePrefix, SecretPath: "ci:services:payments"},
+			allowed: []string{"ci:services:payments:*"},
+			wantValues: map[string]string{
+				"API_TOKEN":   "abc",
+				"DB_PASSWORD": "hunter2",
+				"DB_USER":     "app",
+			},
+		},
+		{
+			name:       "secrets outside the allowed paths fail",
+			item:       SecretToRetrieve{Type: TypePrefix, SecretPath: "ci:services:payments"},
+			allowed:    []string{"ci:services:payments:db"},
+			wantValues: map[string]string{"DB_PASSWORD": "hunter2", "DB_USER": "app"},
+			wantErr:    true,
+		},
+		{
+			name:    "too many matches",
+			item:    SecretToRetrieve{Type: TypePrefix, SecretPath: "ci:services:payments", MaxMatches: 1},
+			wantErr: true,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			is := is.New(t)
+			cfg := &Config{}
+			if len(tc.allowed) > 0 {
+				cfg.guard = cfg.guard.restrict("DSV_ALLOWED_PATHS", tc.allowed)
+			}
+			// Each reading of the clock is a millisecond later, so a latency covering more than one fetch would be longer.
+			clock := time.Time{}
+			now := func() time.Time {
+				clock = clock.Add(time.Millisecond)
+				return clock
+			}
+			results := resolvePrefix(server, "https://example.com/v1", "token", tc.item, cfg, now)
+
+			got := map[string]string{}
+			for _, res := range results {
+				if res.Status == StatusRetrieved {
+					got[res.Item.OutputVariable] = res.Value
+					is.Equal(res.Latency, time.Millisecond) // Each value should have the latency of fetching its own secret.
+				}
+			}
+			is.Equal(len(failures(results)) > 0, tc.wantErr) // Failures should match.
+			if len(tc.wantValues) > 0 {
+				is.Equal(got, tc.wantValues) // Exported values should match.
+			}
+		})
+	}
+}
+
+func TestRunPrefixClash(t *testing.T) {
+	pterm.DisableOutput()
+	is := is.New(t)
+	server := searchServer{
+		pages:   [][]string{{"ci:app:db"}},
+		secrets: map[string]map[string]any{"ci:db": {"password": "hunter2"}, "ci:app:db": {"password": "app-password"}},
+	}
+	fsys := NewMemFS()
+	const envFile, summaryFile = "/runner/env", "/runner/summary"
+	fsys.WriteFile(envFile, nil, PermissionReadWriteOwner)
+	fsys.WriteFile(summaryFile, nil, PermissionReadWriteOwner)
+	environ := map[string]string{EnvFileVariable: envFile, StepSummaryVariable: summaryFile}
+	r := &Runner{
+		Config: Config{
+			IsCI:            true,
+			DomainEnv:       "example.secretsvaultcloud.com",
+			ClientIDEnv:     "client-id",
+			ClientSecretEnv: "client-secret",
+			OnMissingEnv:    OnMissingFail,
+			// The prefix item names its output DB_PASSWORD too, which is only known once it's resolved.
+			RetrieveEnv: `[{"secretPath":"ci:db","secretKey":"password","outputVariable":"DB_PASSWORD"},{"type":"prefix","secretPath":"ci:app","secretKey":"password"}]`,
+		},
+		HTTP:   server,
+		FS:     fsys,
+		Output: io.Discard,
+		LookupEnv: func(key string) (string, bool) {
+			val, ok := environ[key]
+			return val, ok
+		},
+	}
+
+	err := r.Run()
+	is.Equal(ExitCode(err), KindConfig.ExitCode()) // A clash should fail the run.
+	env, err := fsys.ReadFile(envFile)
+	is.NoErr(err)             // Should read env file.
+	is.Equal(string(env), "") // Nothing should be exported.
+	summary, err := fsys.ReadFile(summaryFile)
+	is.NoErr(err)                                                 // Should read summary file.
+	is.True(!strings.Contains(string(summary), "items resolved")) // Summary shouldn't report the values as exported.
+}
+
+func TestValidatePrefixItems(t *testing.T) {
+	is := is.New(t)
+	is.Equal(len(validatePrefixItems([]SecretToRetrieve{{Type: TypePrefix, SecretPath: "a:b"}})), 0)                            // Minimal prefix item is valid.
+	is.Equal(len(validatePrefixItems([]SecretToRetrieve{{Type: "folder", SecretPath: "a:b"}})), 1)                              // Unknown type is invalid.
+	is.Equal(len(validatePrefixItems([]SecretToRetrieve{{Type: TypePrefix, SecretPath: "a:b", OutputTemplate: "{{.Nope"}})), 1) // Broken template is invalid.
+	is.Equal(len(validatePrefixItems([]SecretToRetrieve{{Type: TypePrefix, SecretPath: "a:b", OutputVariable: "X"}})), 1)       // Output variable is invalid.
+}
+
+func TestEnvName(t *testing.T) {
+	is := is.New(t)
+	is.Equal(envName("db:primary_password"), "DB_PRIMARY_PASSWORD") // Separators should become underscores.
+	is.Equal(envName("1st-key"), "_1ST_KEY")                        // Leading digit should be prefixed.
+}
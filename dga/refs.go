@@ -0,0 +1,120 @@
+package dga
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/pterm/pterm"
+)
+
+// defaultMaxRefDepth is the number of references followed from one value when DSV_MAX_REF_DEPTH isn't set.
+const defaultMaxRefDepth = 5
+
+// refKey is the field of a value that points at another secret, such as {"$ref": "shared:db:primary#password"}.
+const refKey = "$ref"
+
+// parseRef returns the target of a value that is a reference, either as an object or a JSON string holding one.
+func parseRef(val any) (string, bool) {
+	switch v := val.(type) {
+	case map[string]any:
+		if len(v) != 1 {
+			return "", false
+		}
+		target, ok := v[refKey].(string)
+		return target, ok
+	case string:
+		trimmed := strings.TrimSpace(v)
+		if !strings.HasPrefix(trimmed, "{") || !strings.Contains(trimmed, refKey) {
+			return "", false
+		}
+		var obj map[string]any
+		if err := json.Unmarshal([]byte(trimmed), &obj); err != nil {
+			return "", false
+		}
+		return parseRef(obj)
+	default:
+		return "", false
+	}
+}
+
+// refCandidate splits a reference target of the form "path#key" into the secret to read.
+// Without a key the referring key is used, so {"$ref": "shared:db"} reads the same key from another secret.
+func refCandidate(target, key string) (SecretCandidate, error) {
+	path, refKey, hasKey := strings.Cut(target, "#")
+	if hasKey {
+		key = refKey
+	}
+	if normalizePath(path) == "" || key == "" {
+		return SecretCandidate{}, fmt.Errorf("reference %q needs a path and key", target)
+	}
+	return SecretCandidate{SecretPath: path, SecretKey: key}, nil
+}
+
+// followRefs returns val, or when val is a reference the value it eventually points at.
+// References are read with the same access token, so DSV only returns targets the client credentials are allowed to read.
+func followRefs(
+	c HTTPClient,
+	apiEndpoint, token string,
+	from SecretCandidate,
+	val any,
+	cfg *Config,
+) (string, error) {
+	maxDepth := cfg.MaxRefDepth
+	if maxDepth <= 0 {
+		maxDepth = defaultMaxRefDepth
+	}
+	chain := []string{from.String()}
+	seen := map[string]bool{normalizePath(from.SecretPath) + "#" + from.SecretKey: true}
+	current := from
+
+	for {
+		target, isRef := parseRef(val)
+		if !isRef {
+			s, ok := val.(string)
+			if !ok && len(chain) == 1 {
+				return "", fmt.Errorf("%q: specified field %q: %w", current.SecretPath, current.SecretKey, ErrKeyMissing)
+			}
+			if !ok {
+				return "", fmt.Errorf("%q: reference target %q is not a string", from, current)
+			}
+			if len(chain) > 1 {
+				pterm.Debug.Printfln("followed reference chain: %s", strings.Join(chain, " -> "))
+			}
+			return s, nil
+		}
+
+		next, err := refCandidate(target, current.SecretKey)
+		if err != nil {
+			return "", fmt.Errorf("%q: %w", current, err)
+		}
+		chain = append(chain, next.String())
+		id := normalizePath(next.SecretPath) + "#" + next.SecretKey
+		if seen[id] {
+			return "", fmt.Errorf("circular reference: %s", strings.Join(chain, " -> "))
+		}
+		if len(chain)-1 > maxDepth {
+			return "", fmt.Errorf("reference chain is deeper than %d: %s", maxDepth, strings.Join(chain, " -> "))
+		}
+		seen[id] = true
+
+		pterm.Debug.Printfln("%q: following reference to %q", current, next)
+		secret, err := getSecret(c, apiEndpoint, token, next.SecretPath, "", cfg)
+		if err != nil {
+			// A broken reference is a problem with the secret itself, so it's never treated as missing.
+			var statusErr *StatusError
+			if errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusForbidden {
+				return "", fmt.Errorf("%q: credentials aren't allowed to read reference target %q: %w", current, next, err)
+			}
+			return "", fmt.Errorf("%q: unable to read reference target %q: %w", current, next, err)
+		}
+		var ok bool
+		val, ok = secret.Data[next.SecretKey]
+		if !ok {
+			return "", fmt.Errorf("%q: reference target %q has no key %q", current, next.SecretPath, next.SecretKey)
+		}
+		current = next
+	}
+}
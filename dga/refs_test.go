@@ -0,0 +1,49 @@
+package dga
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/matryer/is"
+	"github.com/pterm/pterm"
+)
+
+func TestResolveItemFollowRefs(t *testing.T) {
+	pterm.DisableOutput()
+	server := routes{
+		"/v1/secrets/app:db":            {status: http.StatusOK, body: `{"data":{"password":{"$ref":"shared:db:primary#password"},"user":"{\"$ref\": \"shared:db:primary\"}","loop":{"$ref":"app:loop#loop"},"deep":{"$ref":"app:deep1#deep"},"locked":{"$ref":"locked:db#password"},"dangling":{"$ref":"shared:nope#password"}}}`},
+		"/v1/secrets/shared:db:primary": {status: http.StatusOK, body: `{"data":{"password":"hunter2","user":"app"}}`},
+		"/v1/secrets/app:loop":          {status: http.StatusOK, body: `{"data":{"loop":{"$ref":"app:db#loop"}}}`},
+		"/v1/secrets/app:deep1":         {status: http.StatusOK, body: `{"data":{"deep":{"$ref":"app:deep2#deep"}}}`},
+		"/v1/secrets/app:deep2":         {status: http.StatusOK, body: `{"data":{"deep":{"$ref":"app:deep3#deep"}}}`},
+		"/v1/secrets/app:deep3":         {status: http.StatusOK, body: `{"data":{"deep":"bottom"}}`},
+		"/v1/secrets/locked:db":         {status: http.StatusForbidden},
+	}
+	cases := []struct {
+		name       string
+		key        string
+		maxDepth   int
+		follow     bool
+		wantStatus ItemStatus
+		wantValue  string
+	}{
+		{name: "object reference", key: "password", follow: true, wantStatus: StatusRetrieved, wantValue: "hunter2"},
+		{name: "string reference without key uses the same key", key: "user", follow: true, wantStatus: StatusRetrieved, wantValue: "app"},
+		{name: "references are exported as is when not followed", key: "user", follow: false, wantStatus: StatusRetrieved, wantValue: `{"$ref": "shared:db:primary"}`},
+		{name: "chain within depth", key: "deep", follow: true, maxDepth: 3, wantStatus: StatusRetrieved, wantValue: "bottom"},
+		{name: "chain deeper than limit", key: "deep", follow: true, maxDepth: 2, wantStatus: StatusFailed},
+		{name: "cycle", key: "loop", follow: true, wantStatus: StatusFailed},
+		{name: "target the credentials can't read", key: "locked", follow: true, wantStatus: StatusFailed},
+		{name: "dangling reference", key: "dangling", follow: true, wantStatus: StatusFailed},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			is := is.New(t)
+			cfg := &Config{FollowRefs: tc.follow, MaxRefDepth: tc.maxDepth}
+			item := SecretToRetrieve{SecretPath: "app:db", SecretKey: tc.key, OutputVariable: "OUT"}
+			res := applyMissingPolicy(resolveItem(server, "https://example.com/v1", "token", item, cfg), OnMissingSkip)
+			is.Equal(tc.wantStatus, res.Status) // Status should match, and broken references should never be skipped as missing.
+			is.Equal(tc.wantValue, res.Value)   // Value should match.
+		})
+	}
+}
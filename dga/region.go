@@ -0,0 +1,94 @@
+package dga
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Regions are the domain suffixes of DSV's regions, the same list as the domain input in action.yml.
+//
+//nolint:gochecknoglobals // read only list.
+var Regions = []string{
+	"secretsvaultcloud.com",
+	"secretsvaultcloud.eu",
+	"secretsvaultcloud.com.au",
+	"secretsvaultcloud.ca",
+}
+
+// domainHost returns the host of a domain, without any port.
+func domainHost(domain string) string {
+	if host, _, err := net.SplitHostPort(domain); err == nil {
+		return host
+	}
+	return domain
+}
+
+// regionOf returns the region suffix of a domain such as example.secretsvaultcloud.com, or "" when it isn't in a known region.
+func regionOf(domain string) string {
+	host := strings.ToLower(strings.TrimSuffix(domainHost(domain), "."))
+	for _, region := range Regions {
+		if strings.HasSuffix(host, "."+region) && len(host) > len(region)+1 {
+			return region
+		}
+	}
+	return ""
+}
+
+// maxRegionTypo is the most edits between a domain's suffix and a region for it to be suggested as a typo.
+const maxRegionTypo = 2
+
+// suggestRegion returns the region a domain most likely meant, such as secretsvaultcloud.com for example.secretsvaultcloud.co, or "".
+func suggestRegion(domain string) string {
+	host := strings.ToLower(domainHost(domain))
+	best, bestDistance := "", maxRegionTypo+1
+	for _, region := range Regions {
+		// Compare against the end of the host with the same number of labels as the region.
+		labels := strings.Split(host, ".")
+		n := strings.Count(region, ".") + 1
+		if len(labels) <= n {
+			continue
+		}
+		suffix := strings.Join(labels[len(labels)-n:], ".")
+		if d := editDistance(suffix, region); d < bestDistance {
+			best, bestDistance = region, d
+		}
+	}
+	return best
+}
+
+// editDistance is the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+// checkDomain checks the domain is a host name in one of DSV's regions, unless custom domains are allowed for a private deployment.
+// A typo such as example.secretsvaultcloud.co fails here, with a suggestion, instead of as a DNS error.
+func checkDomain(domain string, allowCustom bool) error {
+	if strings.Contains(domain, "/") {
+		return fmt.Errorf("domain %q should be a host name without a scheme or path, set baseUrl to use a URL", domain)
+	}
+	if allowCustom || regionOf(domain) != "" {
+		return nil
+	}
+	if region := suggestRegion(domain); region != "" {
+		return fmt.Errorf("domain %q isn't in a known region, did you mean a domain ending in %s?", domain, region)
+	}
+	return fmt.Errorf("domain %q isn't in a known region (%s), set allowCustomDomain for a private deployment", domain, strings.Join(Regions, ", "))
+}
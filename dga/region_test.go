@@ -0,0 +1,62 @@
+package dga
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func TestRegion(t *testing.T) {
+	cases := []struct {
+		domain      string
+		wantRegion  string
+		wantSuggest string
+	}{
+		{domain: "example.secretsvaultcloud.com", wantRegion: "secretsvaultcloud.com"},
+		{domain: "Example.SecretsVaultCloud.com.au.", wantRegion: "secretsvaultcloud.com.au"},
+		{domain: "example.secretsvaultcloud.eu:443", wantRegion: "secretsvaultcloud.eu"},
+		{domain: "secretsvaultcloud.com"},
+		{domain: "example.secretsvaultcloud.co", wantSuggest: "secretsvaultcloud.com"},
+		{domain: "example.secretvaultcloud.ca", wantSuggest: "secretsvaultcloud.ca"},
+		{domain: "vault.example.internal"},
+		{domain: "127.0.0.1:8443"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.domain, func(t *testing.T) {
+			is := is.New(t)
+			is.Equal(regionOf(tc.domain), tc.wantRegion) // Region should be found from the suffix.
+			if tc.wantRegion == "" {
+				is.Equal(suggestRegion(tc.domain), tc.wantSuggest) // Only a near miss of a region should be suggested.
+			}
+		})
+	}
+}
+
+func TestCheckDomain(t *testing.T) {
+	cases := []struct {
+		name        string
+		domain      string
+		allowCustom bool
+		wantErr     string // wantErr is part of the error, which isn't expected when empty.
+	}{
+		{name: "region", domain: "example.secretsvaultcloud.eu"},
+		{name: "typo", domain: "example.secretsvaultcloud.co", wantErr: "did you mean a domain ending in secretsvaultcloud.com"},
+		{name: "private deployment", domain: "vault.example.internal", wantErr: "set allowCustomDomain"},
+		{name: "private deployment allowed", domain: "vault.example.internal", allowCustom: true},
+		{name: "typo allowed", domain: "example.secretsvaultcloud.co", allowCustom: true},
+		{name: "URL", domain: "https://example.secretsvaultcloud.com/v1", allowCustom: true, wantErr: "set baseUrl"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			is := is.New(t)
+			err := checkDomain(tc.domain, tc.allowCustom)
+			if tc.wantErr == "" {
+				is.NoErr(err) // Domain should be accepted.
+				return
+			}
+			is.True(err != nil)                                // Domain should be refused.
+			is.True(strings.Contains(err.Error(), tc.wantErr)) // Error should say how to fix the domain.
+		})
+	}
+}
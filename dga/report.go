@@ -0,0 +1,118 @@
+package dga
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pterm/pterm"
+)
+
+// ItemStatus describes where the value exported for an item came from.
+type ItemStatus string
+
+const (
+	StatusRetrieved ItemStatus = "retrieved" // StatusRetrieved is a value read from DSV.
+	StatusDefault   ItemStatus = "default"   // StatusDefault is the item's default, used because the secret or key was missing.
+	StatusSkipped   ItemStatus = "skipped"   // StatusSkipped is a missing item that wasn't exported.
+	StatusFailed    ItemStatus = "failed"    // StatusFailed is an item that stops the run.
+)
+
+// itemResult is the outcome of resolving one SecretToRetrieve.
+// Value holds the secret and must never be logged.
+type itemResult struct {
+	Item     SecretToRetrieve
+	Status   ItemStatus
+	Value    string
+	Metadata *SecretMetadata // Metadata is only set for values read from DSV.
+	Used     SecretCandidate // Used is the path and key the value was read from, which differs from Item when a fallback was used.
+	Latency  time.Duration   // Latency is how long the item took to resolve, or for a value of a prefix item, how long its secret took to fetch.
+	Err      error
+}
+
+// failures returns the error of every failed item, classified with a hint on how to fix it.
+func failures(results []itemResult) []error {
+	var errs []error
+	for _, res := range results {
+		if res.Status == StatusFailed {
+			path := res.Item.SecretPath
+			if res.Used.SecretPath != "" {
+				path = res.Used.SecretPath
+			}
+			errs = append(errs, newError(res.Err, KindUnknown, path))
+		}
+	}
+	return errs
+}
+
+// exportedItems returns the item of every result that will be exported.
+func exportedItems(results []itemResult) []SecretToRetrieve {
+	var items []SecretToRetrieve
+	for _, res := range results {
+		switch res.Status {
+		case StatusRetrieved, StatusDefault, StatusComposed:
+			items = append(items, res.Item)
+		}
+	}
+	return items
+}
+
+// printReport renders a table of every item and where its value came from, without the values themselves.
+func printReport(results []itemResult) {
+	data := [][]string{{"Secret Path", "Secret Key", "Output Variable", "Used", "Version", "Status"}}
+	for _, res := range results {
+		version := ""
+		if res.Metadata != nil {
+			version = res.Metadata.Version
+		}
+		used := ""
+		if res.Used.SecretPath != "" {
+			used = res.Used.String()
+		}
+		data = append(data, []string{
+			res.Item.SecretPath,
+			res.Item.SecretKey,
+			strings.ToUpper(res.Item.OutputVariable),
+			used,
+			version,
+			string(res.Status),
+		})
+	}
+	if err := pterm.DefaultTable.WithHasHeader().WithData(data).Render(); err != nil {
+		pterm.Warning.Printfln("unable to render report: %v", err)
+	}
+}
+
+// failureReason is a short description of why an item failed, for people scanning a report.
+func failureReason(err error) string {
+	var statusErr *StatusError
+	switch {
+	case errors.Is(err, ErrNotAllowed):
+		return "denied by policy"
+	case errors.Is(err, ErrNotFound):
+		return "missing"
+	case errors.Is(err, ErrInvalidValue):
+		return "invalid value"
+	case errors.As(err, &statusErr) && (statusErr.StatusCode == http.StatusForbidden || statusErr.StatusCode == http.StatusUnauthorized):
+		return "forbidden"
+	default:
+		return "error"
+	}
+}
+
+// resultWarnings lists what a person should know about an item that didn't fail, such as a fallback or default being used.
+func resultWarnings(res itemResult) []string {
+	var warnings []string
+	switch res.Status {
+	case StatusDefault:
+		warnings = append(warnings, "missing, used the default")
+	case StatusSkipped:
+		warnings = append(warnings, "missing, skipped")
+	case StatusRetrieved:
+		if res.Used.SecretPath != "" && (res.Used.SecretPath != res.Item.SecretPath || res.Used.SecretKey != res.Item.SecretKey) {
+			warnings = append(warnings, "used fallback "+res.Used.String())
+		}
+	}
+	return warnings
+}
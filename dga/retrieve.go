@@ -0,0 +1,188 @@
+package dga
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/DelineaXPM/dsv-github-action/dga/workflow"
+	"github.com/pterm/pterm"
+)
+
+// Policies for DSV_ON_MISSING, applied when a secret or key doesn't exist and the item has no default.
+const (
+	OnMissingFail = "fail" // OnMissingFail stops the run, the same as before optional items existed.
+	OnMissingWarn = "warn" // OnMissingWarn skips the item and logs a warning.
+	OnMissingSkip = "skip" // OnMissingSkip skips the item quietly.
+)
+
+// ErrNotFound is wrapped by errors for a secret or key that doesn't exist, as opposed to one that couldn't be read.
+var ErrNotFound = errors.New("not found")
+
+// validateOnMissing checks the DSV_ON_MISSING policy is one that's supported.
+func validateOnMissing(policy string) error {
+	switch policy {
+	case OnMissingFail, OnMissingWarn, OnMissingSkip:
+		return nil
+	default:
+		return fmt.Errorf("DSV_ON_MISSING %q is not supported, use %q, %q or %q", policy, OnMissingFail, OnMissingWarn, OnMissingSkip)
+	}
+}
+
+// validateItems checks the retrieve configuration before anything is requested from DSV.
+func validateItems(items []SecretToRetrieve) error {
+	var single []SecretToRetrieve
+	for _, item := range items {
+		if item.Type != TypePrefix {
+			single = append(single, item)
+		}
+	}
+	errs := validatePrefixItems(items)
+	errs = append(errs, validateComposeItems(items)...)
+	errs = append(errs, validateTransforms(items)...)
+	errs = append(errs, validateRules(items)...)
+	errs = append(errs, validateOutputVariables(single), validateOptional(items), validateFallback(items))
+	return errors.Join(errs...)
+}
+
+// validateFallback checks every fallback candidate has a path to try.
+func validateFallback(items []SecretToRetrieve) error {
+	var errs []error
+	for _, item := range items {
+		if len(item.Fallback) > 0 && item.Type == TypePrefix {
+			errs = append(errs, fmt.Errorf("%q: fallback can't be used with a prefix item", item.SecretPath))
+		}
+		for i, candidate := range item.Fallback {
+			if candidate.SecretPath == "" {
+				errs = append(errs, fmt.Errorf("%q: fallback %d needs a secretPath", item.SecretPath, i+1))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// validateOptional checks that no item is both required and has a default, as the default would never be used.
+func validateOptional(items []SecretToRetrieve) error {
+	var errs []error
+	for _, item := range items {
+		if item.Default != nil && item.Required != nil && *item.Required {
+			errs = append(errs, fmt.Errorf("%q: an item can't be required and have a default", item.SecretPath))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// resolveItem fetches the secret for a single item and returns the value of its key.
+// Candidates are tried in order, moving to the next only when a secret or key doesn't exist, so a permission or server error is never hidden by a fallback.
+func resolveItem(c HTTPClient, apiEndpoint, token string, item SecretToRetrieve, cfg *Config) itemResult {
+	res := itemResult{Item: item, Status: StatusFailed}
+
+	var missing []error
+	for _, candidate := range item.candidates() {
+		val, metadata, err := resolveCandidate(c, apiEndpoint, token, item, candidate, cfg)
+		if err == nil {
+			if val, err = applyTransforms(item.Transforms, val); err != nil {
+				pterm.Error.Printfln("%q: %v", item, err)
+				res.Err = fmt.Errorf("%q: %w", item, err)
+				return res
+			}
+			if len(missing) > 0 {
+				cfg.workflowCommands().Notice(workflow.Annotation{Title: "DSV fallback used"}, fmt.Sprintf("%q is missing, used %q instead", item, candidate))
+			}
+			res.Status = StatusRetrieved
+			res.Value = val
+			res.Metadata = &metadata
+			res.Used = candidate
+			return res
+		}
+		if !errors.Is(err, ErrNotFound) {
+			res.Err = err
+			return res
+		}
+		if len(item.Fallback) > 0 {
+			pterm.Info.Printfln("%q: %q is missing, trying the next candidate", item, candidate)
+		}
+		missing = append(missing, err)
+	}
+	res.Err = errors.Join(missing...)
+	return res
+}
+
+// resolveCandidate fetches one candidate secret and returns the value of its key.
+func resolveCandidate(
+	c HTTPClient,
+	apiEndpoint, token string,
+	item SecretToRetrieve,
+	candidate SecretCandidate,
+	cfg *Config,
+) (string, SecretMetadata, error) {
+	pterm.Debug.Printfln("start processing: SecretPath: %s SecretKey: %s", candidate.SecretPath, candidate.SecretKey)
+	lookup := item
+	lookup.SecretPath = candidate.SecretPath
+	lookup.SecretKey = candidate.SecretKey
+	lookup.Version = candidate.Version
+
+	secret, err := getSecret(c, apiEndpoint, token, lookup.SecretPath, lookup.Version.String(), cfg)
+	if err != nil {
+		pterm.Error.Printfln("%q: Failed to fetch secret: %v", lookup, err)
+		var statusErr *StatusError
+		if errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusNotFound {
+			err = fmt.Errorf("%w: %w", ErrNotFound, err)
+		}
+		return "", SecretMetadata{}, fmt.Errorf("%q: unable to get secret: %w", lookup.SecretPath, err)
+	}
+	pterm.Success.Printfln("retrieved successfully: %q", lookup)
+
+	raw, ok := secret.Data[lookup.SecretKey]
+	if len(item.Transforms) > 0 {
+		raw = structuredAsJSON(raw)
+	}
+	if cfg.FollowRefs && ok {
+		val, err := followRefs(c, apiEndpoint, token, candidate, raw, cfg)
+		if err != nil {
+			pterm.Error.Printfln("%q: %v", lookup, err)
+			return "", SecretMetadata{}, err
+		}
+		return val, metadataFromSecret(secret), nil
+	}
+	val, ok := raw.(string)
+	if !ok {
+		pterm.Error.Printfln("%q: Key %q not found in data", lookup, lookup.SecretKey)
+		return "", SecretMetadata{}, fmt.Errorf("%q: specified field %q: %w", lookup.SecretPath, lookup.SecretKey, ErrKeyMissing)
+	}
+
+	pterm.Debug.Printfln("%q: Found %q key in data", lookup, lookup.SecretKey)
+	return val, metadataFromSecret(secret), nil
+}
+
+// applyMissingPolicy decides what happens to an item whose secret or key doesn't exist.
+// A default always wins, then an explicit required flag, and otherwise the DSV_ON_MISSING policy.
+func applyMissingPolicy(res itemResult, policy string) itemResult {
+	if res.Err == nil || !errors.Is(res.Err, ErrNotFound) {
+		return res
+	}
+	item := res.Item
+
+	if item.Default != nil {
+		pterm.Warning.Printfln("%q: %q is missing, using the default value", item.SecretPath, item.SecretKey)
+		return itemResult{Item: item, Status: StatusDefault, Value: *item.Default}
+	}
+	if item.Required != nil {
+		if *item.Required {
+			return res
+		}
+		if policy == OnMissingFail {
+			policy = OnMissingWarn
+		}
+	}
+
+	switch policy {
+	case OnMissingWarn:
+		pterm.Warning.Printfln("%q: %q is missing, skipping: %v", item.SecretPath, item.SecretKey, res.Err)
+	case OnMissingSkip:
+		pterm.Info.Printfln("%q: %q is missing, skipping", item.SecretPath, item.SecretKey)
+	default:
+		return res
+	}
+	return itemResult{Item: item, Status: StatusSkipped}
+}
@@ -0,0 +1,274 @@
+package dga
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/DelineaXPM/dsv-github-action/dga/workflow"
+	"github.com/matryer/is"
+	"github.com/pterm/pterm"
+)
+
+// responder returns a fixed status and body for every request.
+type responder struct {
+	status int
+	body   string
+}
+
+func (r responder) Do(req *http.Request) (*http.Response, error) {
+	return &http.Response{
+		Status:     fmt.Sprintf("%d %s", r.status, http.StatusText(r.status)),
+		StatusCode: r.status,
+		Body:       io.NopCloser(bytes.NewReader([]byte(r.body))),
+	}, nil
+}
+
+// recorder captures the last request and returns an empty secret.
+type recorder struct {
+	req *http.Request
+}
+
+func (r *recorder) Do(req *http.Request) (*http.Response, error) {
+	r.req = req
+	return responder{status: http.StatusOK, body: `{"data":{}}`}.Do(req)
+}
+
+// routes answers each request by URL path, and 404 for anything else.
+type routes map[string]responder
+
+func (r routes) Do(req *http.Request) (*http.Response, error) {
+	if route, ok := r[req.URL.Path]; ok {
+		return route.Do(req)
+	}
+	return responder{status: http.StatusNotFound}.Do(req)
+}
+
+func ptr[T any](v T) *T { return &v }
+
+func TestResolveItem(t *testing.T) {
+	pterm.DisableOutput()
+	item := SecretToRetrieve{SecretPath: "folder1:secret1", SecretKey: "key", OutputVariable: "OUT"}
+	cases := []struct {
+		name        string
+		client      HTTPClient
+		wantStatus  ItemStatus
+		wantValue   string
+		wantMissing bool
+	}{
+		{
+			name:       "found",
+			client:     responder{status: http.StatusOK, body: `{"data":{"key":"val"}}`},
+			wantStatus: StatusRetrieved,
+			wantValue:  "val",
+		},
+		{
+			name:        "secret not found",
+			client:      responder{status: http.StatusNotFound},
+			wantStatus:  StatusFailed,
+			wantMissing: true,
+		},
+		{
+			name:        "key not found",
+			client:      responder{status: http.StatusOK, body: `{"data":{"other":"val"}}`},
+			wantStatus:  StatusFailed,
+			wantMissing: true,
+		},
+		{
+			name:        "forbidden is not missing",
+			client:      responder{status: http.StatusForbidden},
+			wantStatus:  StatusFailed,
+			wantMissing: false,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			is := is.New(t)
+			var commands bytes.Buffer
+			res := resolveItem(tc.client, "https://example.com/v1", "token", item, &Config{commands: workflow.New(&commands)})
+			is.Equal(tc.wantStatus, res.Status)                       // Status should match.
+			is.Equal(tc.wantValue, res.Value)                         // Value should match.
+			is.Equal(tc.wantMissing, errors.Is(res.Err, ErrNotFound)) // Missing should only be flagged for 404 and absent keys.
+			is.Equal(commands.String(), "")                           // Nothing should be annotated without a fallback.
+		})
+	}
+}
+
+func TestResolveItemFallback(t *testing.T) {
+	pterm.DisableOutput()
+	server := routes{
+		"/v1/secrets/app:prod:feature-x": {status: http.StatusOK, body: `{"data":{"other":"x"}}`},
+		"/v1/secrets/app:prod:default":   {status: http.StatusOK, body: `{"data":{"key":"default-value","alt":"alt-value"}}`},
+		"/v1/secrets/app:prod:forbidden": {status: http.StatusForbidden},
+	}
+	cases := []struct {
+		name        string
+		item        SecretToRetrieve
+		wantStatus  ItemStatus
+		wantValue   string
+		wantUsed    SecretCandidate
+		wantMissing bool
+		wantNotice  string // wantNotice is the annotation for a fallback, which isn't expected when empty.
+	}{
+		{
+			name: "missing key falls back to next path",
+			item: SecretToRetrieve{
+				SecretPath: "app:prod:feature-x", SecretKey: "key",
+				Fallback: []SecretCandidate{{SecretPath: "app:prod:default"}},
+			},
+			wantStatus: StatusRetrieved,
+			wantValue:  "default-value",
+			wantUsed:   SecretCandidate{SecretPath: "app:prod:default", SecretKey: "key"},
+			wantNotice: `::notice title=DSV fallback used::"app:prod:feature-x#key" is missing, used "app:prod:default#key" instead` + "\n",
+		},
+		{
+			name: "missing secret falls back to a different key",
+			item: SecretToRetrieve{
+				SecretPath: "app:prod:nope", SecretKey: "key",
+				Fallback: []SecretCandidate{{SecretPath: "app:prod:nope2"}, {SecretPath: "app:prod:default", SecretKey: "alt"}},
+			},
+			wantStatus: StatusRetrieved,
+			wantValue:  "alt-value",
+			wantUsed:   SecretCandidate{SecretPath: "app:prod:default", SecretKey: "alt"},
+			wantNotice: `::notice title=DSV fallback used::"app:prod:nope#key" is missing, used "app:prod:default#alt" instead` + "\n",
+		},
+		{
+			name: "forbidden stops the chain",
+			item: SecretToRetrieve{
+				SecretPath: "app:prod:forbidden", SecretKey: "key",
+				Fallback: []SecretCandidate{{SecretPath: "app:prod:default"}},
+			},
+			wantStatus: StatusFailed,
+		},
+		{
+			name: "every candidate missing",
+			item: SecretToRetrieve{
+				SecretPath: "app:prod:nope", SecretKey: "key",
+				Fallback: []SecretCandidate{{SecretPath: "app:prod:feature-x"}},
+			},
+			wantStatus:  StatusFailed,
+			wantMissing: true,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			is := is.New(t)
+			var commands bytes.Buffer
+			res := resolveItem(server, "https://example.com/v1", "token", tc.item, &Config{commands: workflow.New(&commands)})
+			is.Equal(tc.wantStatus, res.Status)                       // Status should match.
+			is.Equal(tc.wantValue, res.Value)                         // Value should match.
+			is.Equal(tc.wantUsed, res.Used)                           // Used candidate should match.
+			is.Equal(tc.wantMissing, errors.Is(res.Err, ErrNotFound)) // Missing should only be flagged when every candidate is missing.
+			is.Equal(commands.String(), tc.wantNotice)                // A fallback should be annotated.
+		})
+	}
+}
+
+func TestDSVGetSecretVersion(t *testing.T) {
+	pterm.DisableOutput()
+	cases := []struct {
+		name      string
+		version   json.Number
+		wantQuery string
+	}{
+		{name: "latest", version: "", wantQuery: ""},
+		{name: "pinned", version: "3", wantQuery: "version=3"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			is := is.New(t)
+			rec := &recorder{}
+			item := SecretToRetrieve{SecretPath: "folder1:secret1", SecretKey: "key", Version: tc.version}
+			_, err := DSVGetSecret(rec, "https://example.com/v1", "token", item, &Config{})
+			is.NoErr(err)                                // Request should succeed.
+			is.Equal(rec.req.URL.RawQuery, tc.wantQuery) // Version should only be sent when pinned.
+		})
+	}
+}
+
+func TestApplyMissingPolicy(t *testing.T) {
+	pterm.DisableOutput()
+	missing := fmt.Errorf("%q: unable to get secret: %w", "a:b", ErrNotFound)
+	cases := []struct {
+		name       string
+		item       SecretToRetrieve
+		err        error
+		policy     string
+		wantStatus ItemStatus
+		wantValue  string
+	}{
+		{
+			name:       "fail policy fails",
+			err:        missing,
+			policy:     OnMissingFail,
+			wantStatus: StatusFailed,
+		},
+		{
+			name:       "warn policy skips",
+			err:        missing,
+			policy:     OnMissingWarn,
+			wantStatus: StatusSkipped,
+		},
+		{
+			name:       "skip policy skips",
+			err:        missing,
+			policy:     OnMissingSkip,
+			wantStatus: StatusSkipped,
+		},
+		{
+			name:       "default wins over fail policy",
+			item:       SecretToRetrieve{Default: ptr("fallback")},
+			err:        missing,
+			policy:     OnMissingFail,
+			wantStatus: StatusDefault,
+			wantValue:  "fallback",
+		},
+		{
+			name:       "required wins over skip policy",
+			item:       SecretToRetrieve{Required: ptr(true)},
+			err:        missing,
+			policy:     OnMissingSkip,
+			wantStatus: StatusFailed,
+		},
+		{
+			name:       "optional item is skipped under fail policy",
+			item:       SecretToRetrieve{Required: ptr(false)},
+			err:        missing,
+			policy:     OnMissingFail,
+			wantStatus: StatusSkipped,
+		},
+		{
+			name:       "other errors are never skipped",
+			item:       SecretToRetrieve{Default: ptr("fallback")},
+			err:        fmt.Errorf("forbidden"),
+			policy:     OnMissingSkip,
+			wantStatus: StatusFailed,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			is := is.New(t)
+			res := applyMissingPolicy(itemResult{Item: tc.item, Status: StatusFailed, Err: tc.err}, tc.policy)
+			is.Equal(tc.wantStatus, res.Status) // Status should match.
+			is.Equal(tc.wantValue, res.Value)   // Value should match.
+		})
+	}
+}
+
+func TestValidateOptional(t *testing.T) {
+	is := is.New(t)
+	is.NoErr(validateOptional([]SecretToRetrieve{{Required: ptr(false), Default: ptr("x")}}))      // Optional item with a default is valid.
+	is.True(validateOptional([]SecretToRetrieve{{Required: ptr(true), Default: ptr("x")}}) != nil) // Required item with a default is invalid.
+	is.True(validateOnMissing("ignore") != nil)                                                    // Unknown policy is invalid.
+}
+
+func TestValidateFallback(t *testing.T) {
+	is := is.New(t)
+	is.NoErr(validateFallback([]SecretToRetrieve{{SecretPath: "a", Fallback: []SecretCandidate{{SecretPath: "b"}}}}))                         // Fallback with a path is valid.
+	is.True(validateFallback([]SecretToRetrieve{{SecretPath: "a", Fallback: []SecretCandidate{{SecretKey: "b"}}}}) != nil)                    // Fallback without a path is invalid.
+	is.True(validateFallback([]SecretToRetrieve{{Type: TypePrefix, SecretPath: "a", Fallback: []SecretCandidate{{SecretPath: "b"}}}}) != nil) // Prefix items can't fall back.
+}
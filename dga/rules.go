@@ -0,0 +1,143 @@
+package dga
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// ErrInvalidValue is wrapped by errors for a value that breaks one of its item's validate rules.
+var ErrInvalidValue = errors.New("validation failed")
+
+// hoursPerDay converts certMinDaysValid to a duration.
+const hoursPerDay = 24
+
+// ValueRules are assertions checked against the value an item exports, after any transforms.
+// Failures never include the value, so they are safe to log.
+//
+//nolint:tagliatelle // Here 'camel' casing is used instead of 'kebab'.
+type ValueRules struct {
+	NonEmpty  bool   `json:"nonEmpty,omitempty"`  // NonEmpty fails on an empty or whitespace only value.
+	MinLength int    `json:"minLength,omitempty"` // MinLength is the minimum number of characters.
+	Regex     string `json:"regex,omitempty"`     // Regex must match somewhere in the value, so anchor it with ^ and $ to match all of it.
+	JSON      bool   `json:"json,omitempty"`      // JSON fails unless the value is valid JSON.
+	PEM       bool   `json:"pem,omitempty"`       // PEM fails unless the value is one or more complete PEM blocks.
+	// CertMinDaysValid fails unless the value is PEM with certificates that are all still valid this many days from now.
+	CertMinDaysValid int `json:"certMinDaysValid,omitempty"`
+}
+
+// validateRules checks the rules of every item can be used, such as the regex compiling, before anything is requested from DSV.
+func validateRules(items []SecretToRetrieve) []error {
+	var errs []error
+	for _, item := range items {
+		if item.Validate == nil {
+			continue
+		}
+		if item.Validate.Regex != "" {
+			if _, err := regexp.Compile(item.Validate.Regex); err != nil {
+				errs = append(errs, fmt.Errorf("%q: invalid validate.regex: %w", item, err))
+			}
+		}
+		if item.Validate.MinLength < 0 || item.Validate.CertMinDaysValid < 0 {
+			errs = append(errs, fmt.Errorf("%q: validate.minLength and validate.certMinDaysValid can't be negative", item))
+		}
+	}
+	return errs
+}
+
+// checkValue returns every rule the value breaks.
+func (r ValueRules) checkValue(value string, now time.Time) error {
+	var errs []error
+	if r.NonEmpty && strings.TrimSpace(value) == "" {
+		errs = append(errs, fmt.Errorf("value is empty"))
+	}
+	if n := utf8.RuneCountInString(value); r.MinLength > 0 && n < r.MinLength {
+		errs = append(errs, fmt.Errorf("value is %d characters, shorter than the minimum of %d", n, r.MinLength))
+	}
+	if r.Regex != "" {
+		re, err := regexp.Compile(r.Regex)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid regex: %w", err))
+		} else if !re.MatchString(value) {
+			errs = append(errs, fmt.Errorf("value does not match regex %q", r.Regex))
+		}
+	}
+	if r.JSON && !json.Valid([]byte(value)) {
+		// The decoder's error quotes the offending character, so it isn't included.
+		errs = append(errs, fmt.Errorf("value is not valid JSON"))
+	}
+	if r.PEM || r.CertMinDaysValid > 0 {
+		errs = append(errs, checkPEM(value, r.CertMinDaysValid, now)...)
+	}
+	return errors.Join(errs...)
+}
+
+// checkPEM checks the value is nothing but complete PEM blocks, and when minDays is set that every certificate is valid for that long.
+func checkPEM(value string, minDays int, now time.Time) []error {
+	var errs []error
+	rest := []byte(value)
+	blocks := 0
+	certs := 0
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		blocks++
+		if minDays <= 0 || block.Type != "CERTIFICATE" {
+			continue
+		}
+		certs++
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("certificate %d can't be parsed", certs))
+			continue
+		}
+		deadline := now.Add(time.Duration(minDays) * hoursPerDay * time.Hour)
+		if cert.NotAfter.Before(deadline) {
+			errs = append(errs, fmt.Errorf("certificate %d (%s) expires %s, within %d days", certs, cert.Subject.CommonName, cert.NotAfter.Format(time.DateOnly), minDays))
+		}
+		if now.Before(cert.NotBefore) {
+			errs = append(errs, fmt.Errorf("certificate %d (%s) is not valid until %s", certs, cert.Subject.CommonName, cert.NotBefore.Format(time.DateOnly)))
+		}
+	}
+	if blocks == 0 {
+		return append(errs, fmt.Errorf("value is not PEM, or the PEM is truncated"))
+	}
+	if strings.TrimSpace(string(rest)) != "" {
+		errs = append(errs, fmt.Errorf("value has data after the last complete PEM block, which may be truncated"))
+	}
+	if minDays > 0 && certs == 0 {
+		errs = append(errs, fmt.Errorf("value has no certificate"))
+	}
+	return errs
+}
+
+// checkResult applies the item's rules to a value that's going to be exported, failing the result when any rule is broken.
+func checkResult(res itemResult, now time.Time) itemResult {
+	if res.Item.Validate == nil {
+		return res
+	}
+	switch res.Status {
+	case StatusRetrieved, StatusDefault, StatusComposed:
+	default:
+		return res
+	}
+	if err := res.Item.Validate.checkValue(res.Value, now); err != nil {
+		return itemResult{
+			Item:     res.Item,
+			Status:   StatusFailed,
+			Metadata: res.Metadata,
+			Used:     res.Used,
+			Err:      fmt.Errorf("%q: %w: %w", res.Item, ErrInvalidValue, err),
+		}
+	}
+	return res
+}
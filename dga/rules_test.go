@@ -0,0 +1,86 @@
+package dga
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+// testCertificate returns a self signed PEM certificate valid until notAfter.
+func testCertificate(t *testing.T, notAfter time.Time) string {
+	t.Helper()
+	is := is.New(t)
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	is.NoErr(err) // Should generate key.
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "test.example.com"},
+		NotBefore:    notAfter.Add(-365 * 24 * time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	is.NoErr(err) // Should create certificate.
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+}
+
+func TestValueRulesCheckValue(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	cert := testCertificate(t, now.Add(10*24*time.Hour))
+	cases := []struct {
+		name    string
+		rules   ValueRules
+		value   string
+		wantErr bool
+	}{
+		{name: "no rules", value: ""},
+		{name: "non empty", rules: ValueRules{NonEmpty: true}, value: "x"},
+		{name: "empty", rules: ValueRules{NonEmpty: true}, value: " \n", wantErr: true},
+		{name: "long enough", rules: ValueRules{MinLength: 5}, value: "hunter2"},
+		{name: "too short", rules: ValueRules{MinLength: 12}, value: "hunter2", wantErr: true},
+		{name: "regex", rules: ValueRules{Regex: `^ghp_[A-Za-z0-9]+$`}, value: "ghp_abc123"},
+		{name: "regex mismatch", rules: ValueRules{Regex: `^ghp_[A-Za-z0-9]+$`}, value: "gho_abc123", wantErr: true},
+		{name: "json", rules: ValueRules{JSON: true}, value: `{"a":1}`},
+		{name: "invalid json", rules: ValueRules{JSON: true}, value: `{"a":secretvalue}`, wantErr: true},
+		{name: "pem", rules: ValueRules{PEM: true}, value: cert},
+		{name: "truncated pem", rules: ValueRules{PEM: true}, value: cert[:len(cert)/2], wantErr: true},
+		{name: "pem with trailing data", rules: ValueRules{PEM: true}, value: cert + "-----BEGIN CERT", wantErr: true},
+		{name: "certificate valid long enough", rules: ValueRules{CertMinDaysValid: 5}, value: cert},
+		{name: "certificate expiring soon", rules: ValueRules{CertMinDaysValid: 30}, value: cert, wantErr: true},
+		{name: "certificate rule without a certificate", rules: ValueRules{CertMinDaysValid: 30}, value: "secretvalue", wantErr: true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			is := is.New(t)
+			err := tc.rules.checkValue(tc.value, now)
+			if !tc.wantErr {
+				is.NoErr(err) // Value should pass.
+				return
+			}
+			is.True(err != nil)                                                 // Value should fail.
+			is.True(tc.value == "" || !strings.Contains(err.Error(), tc.value)) // Error should not include the value.
+			is.True(!strings.Contains(err.Error(), "secretvalue"))              // Error should not include part of the value.
+		})
+	}
+}
+
+func TestCheckResult(t *testing.T) {
+	is := is.New(t)
+	rules := &ValueRules{NonEmpty: true}
+	res := checkResult(itemResult{Item: SecretToRetrieve{Validate: rules}, Status: StatusRetrieved, Value: ""}, time.Now())
+	is.Equal(res.Status, StatusFailed) // Broken rule should fail the item.
+	is.Equal(res.Value, "")            // Failed item should not keep the value.
+
+	res = checkResult(itemResult{Item: SecretToRetrieve{Validate: rules}, Status: StatusSkipped}, time.Now())
+	is.Equal(res.Status, StatusSkipped) // Skipped items have nothing to check.
+
+	is.Equal(len(validateRules([]SecretToRetrieve{{Validate: &ValueRules{Regex: "("}}})), 1) // Invalid regex should be reported before running.
+}
@@ -0,0 +1,358 @@
+package dga
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/DelineaXPM/dsv-github-action/dga/workflow"
+	"github.com/pterm/pterm"
+)
+
+// Runner retrieves the secrets in its Config and exports them for later steps.
+// Everything a run reads or writes outside the process is a field, so a whole run can be tested.
+// The zero value of each field, other than Config, uses the real one.
+type Runner struct {
+	Config Config
+	// HTTP sends requests to DSV and the GitHub API, and is an *http.Client with a 5 second timeout when nil.
+	HTTP HTTPClient
+	// FS reads the retrieve file and event payload, and writes the workflow command files. It's OS when nil.
+	FS FileSystem
+	// Now is the clock used for latency and certificate expiry. It's time.Now when nil.
+	Now func() time.Time
+	// Output receives workflow commands, such as masks, groups and annotations. It's os.Stdout when nil.
+	Output io.Writer
+	// LookupEnv reads placeholders and the paths of command files, such as GITHUB_ENV. It's os.LookupEnv when nil.
+	LookupEnv func(key string) (string, bool)
+}
+
+// httpClient returns the HTTP client, or the default one.
+func (r *Runner) httpClient() HTTPClient {
+	if r.HTTP == nil {
+		return &http.Client{Timeout: defaultTimeout}
+	}
+	return r.HTTP
+}
+
+// fileSystem returns the filesystem, or OS.
+func (r *Runner) fileSystem() FileSystem {
+	if r.FS == nil {
+		return OS
+	}
+	return r.FS
+}
+
+// now returns the current time from the clock.
+func (r *Runner) now() time.Time {
+	if r.Now == nil {
+		return time.Now()
+	}
+	return r.Now()
+}
+
+// commands returns the workflow command writer for the output.
+func (r *Runner) commands() *workflow.Writer {
+	if r.Output == nil {
+		return workflow.Default()
+	}
+	return workflow.New(r.Output)
+}
+
+// lookupEnv returns the environment lookup, or os.LookupEnv.
+func (r *Runner) lookupEnv() lookupFunc {
+	if r.LookupEnv == nil {
+		return os.LookupEnv
+	}
+	return r.LookupEnv
+}
+
+// Run authenticates with DSV, retrieves every item, masks the values and exports them to GITHUB_ENV.
+// Nothing is exported unless every item succeeds.
+func (r *Runner) Run() error { //nolint:funlen,cyclop // funlen: this could use refactoring in future to break it apart more, but leaving as is at this time.
+	commands := r.commands()
+	cfg := r.Config
+	if cfg.IsCI {
+		defer configureLogging(commands)()
+	}
+	cfg.fs = r.fileSystem()
+	cfg.commands = commands
+	httpClient := r.httpClient()
+	files := commandFiles{fs: cfg.fs, lookup: r.lookupEnv()}
+
+	cfg.mask(cfg.ClientIDEnv, cfg.ClientSecretEnv)
+
+	if cfg.IsDebug {
+		pterm.Info.Println("DEBUG detected, setting debug output to enabled")
+		pterm.EnableDebugMessages()
+		pterm.Debug.Println("debug messages have been enabled")
+
+		// No %v to avoid exposing secret values.
+		pterm.Debug.Printfln("IsCI            : %v", cfg.IsCI)
+		pterm.Debug.Printfln("IsDebug         : %v", cfg.IsDebug)
+
+		pterm.Debug.Printfln("DomainEnv       : %v", cfg.DomainEnv)
+		pterm.Debug.Printfln("BaseURLEnv      : %v", cfg.BaseURLEnv)
+		pterm.Debug.Printfln("AllowCustomDomain: %v", cfg.AllowCustomDomain)
+		pterm.Debug.Printfln("Insecure        : %v", cfg.Insecure)
+		pterm.Debug.Println("ClientIDEnv     : ** value exists, but not exposing in logs **")
+		pterm.Debug.Println("ClientSecretEnv : ** value exists, but not exposing in logs **")
+		pterm.Debug.Printfln("RetrieveEnv     : %v", cfg.RetrieveEnv)
+		pterm.Debug.Printfln("RetrieveFileEnv : %v", cfg.RetrieveFileEnv)
+		pterm.Debug.Printfln("OnMissingEnv    : %v", cfg.OnMissingEnv)
+		pterm.Debug.Printfln("ExportMetadata  : %v", cfg.ExportMetadata)
+		pterm.Debug.Printfln("FollowRefs      : %v", cfg.FollowRefs)
+		pterm.Debug.Printfln("MaxRefDepth     : %v", cfg.MaxRefDepth)
+		pterm.Debug.Printfln("PolicyFile      : %v", cfg.PolicyFile)
+		pterm.Debug.Printfln("AllowedPathsEnv : %v", cfg.AllowedPathsEnv)
+		pterm.Debug.Printfln("DryRun          : %v", cfg.DryRun)
+	}
+
+	if err := validateOnMissing(cfg.OnMissingEnv); err != nil {
+		pterm.Error.Printfln("invalid configuration: %v", err)
+		return &Error{Kind: KindConfig, Err: err}
+	}
+
+	retrieve, err := loadRetrieve(&cfg)
+	if err != nil {
+		pterm.Error.Printfln("invalid configuration: %v", err)
+		return &Error{Kind: KindConfig, Err: err}
+	}
+
+	configuredValues, err := ParseRetrieve(retrieve)
+	if err != nil {
+		pterm.Error.Printfln("run failure: %v", err)
+		return &Error{Kind: KindConfig, Hint: "retrieve must be a json list of items", Err: err}
+	}
+
+	retrievedValues, err := resolvePlaceholders(configuredValues, files.lookup)
+	if err != nil {
+		pterm.Error.Printfln("unable to resolve placeholders: %v", err)
+		return &Error{Kind: KindConfig, Err: fmt.Errorf("unable to resolve placeholders: %w", err)}
+	}
+	if cfg.IsCI {
+		appendStepSummary(files, resolvedPathsSummary(configuredValues, retrievedValues))
+	}
+
+	if err := validateItems(retrievedValues); err != nil {
+		pterm.Error.Printfln("invalid retrieve configuration: %v", err)
+		return &Error{Kind: KindConfig, Err: fmt.Errorf("invalid retrieve configuration: %w", err)}
+	}
+
+	apiEndpoint, err := cfg.apiEndpoint()
+	if err != nil {
+		pterm.Error.Printfln("invalid configuration: %v", err)
+		return err
+	}
+
+	if err := applyPolicy(httpClient, &cfg, retrievedValues); err != nil {
+		pterm.Error.Printfln("refused by policy, nothing has been retrieved: %v", err)
+		err = newError(fmt.Errorf("refused by policy: %w", err), KindConfig, "")
+		if cfg.IsCI {
+			appendStepSummary(files, failureSummary("refused by policy", err))
+		}
+		return err
+	}
+
+	endGroup := cfg.group("Authenticate with DSV")
+	token, err := DSVGetToken(httpClient, apiEndpoint, &cfg)
+	endGroup()
+	if err != nil {
+		pterm.Error.Printfln("authentication failure: %v", err)
+		tokenErr := tokenError(err)
+		if cfg.IsCI {
+			appendStepSummary(files, failureSummary(tokenErr.Kind.String(), tokenErr))
+		}
+		return tokenErr
+	}
+
+	// Resolve every item before writing anything, so a single failure doesn't leave later steps with a partial set of variables.
+	results := make([]itemResult, 0, len(retrievedValues))
+	for _, item := range retrievedValues {
+		if item.Type == TypeCompose {
+			continue
+		}
+		if item.Type == TypePrefix {
+			endGroup := cfg.group("Retrieve secrets under " + item.SecretPath)
+			// Each value has the latency of fetching its own secret, rather than of the whole prefix.
+			for _, res := range resolvePrefix(httpClient, apiEndpoint, token, item, &cfg, r.now) {
+				res = checkResult(applyMissingPolicy(res, cfg.OnMissingEnv), r.now())
+				if cfg.DryRun {
+					res = forgetValue(res)
+				}
+				results = append(results, res)
+			}
+			endGroup()
+			continue
+		}
+		start := r.now()
+		endGroup := cfg.group("Retrieve " + item.String())
+		res := resolveItem(httpClient, apiEndpoint, token, item, &cfg)
+		res = checkResult(applyMissingPolicy(res, cfg.OnMissingEnv), r.now())
+		res.Latency = r.now().Sub(start)
+		if cfg.DryRun {
+			res = forgetValue(res)
+		}
+		results = append(results, res)
+		endGroup()
+	}
+	if cfg.DryRun {
+		return finishCheck(&cfg, files, retrievedValues, results)
+	}
+	if hasCompose(retrievedValues) {
+		endGroup := cfg.group("Compose values")
+		results = resolveCompose(retrievedValues, results, cfg.OnMissingEnv, r.now())
+		endGroup()
+	}
+	printReport(results)
+
+	// Prefix items name their outputs from what they find, so names can only be checked for clashes now.
+	// It's checked before the summary is written, which would otherwise report values as exported.
+	if err := validateOutputVariables(exportedItems(results)); err != nil {
+		pterm.Error.Printfln("conflicting output variables, nothing has been exported: %v", err)
+		return &Error{Kind: KindConfig, Err: fmt.Errorf("conflicting output variables: %w", err)}
+	}
+	if cfg.IsCI {
+		appendStepSummary(files, resultsSummary(results, cfg.ExportMetadata))
+	}
+
+	if errs := failures(results); len(errs) > 0 {
+		pterm.Error.Printfln("%d of %d items failed, nothing has been exported", len(errs), len(results))
+		return fmt.Errorf("unable to retrieve %d of %d secrets: %w", len(errs), len(results), errors.Join(errs...))
+	}
+
+	batch := newExportBatch()
+	for _, res := range results {
+		if res.Status == StatusSkipped {
+			continue
+		}
+		batch.add(EnvFileVariable, res.Item.OutputVariable, res.Value)
+		if cfg.ExportMetadata && res.Metadata != nil {
+			addMetadataOutputs(batch, res.Item.OutputVariable, *res.Metadata)
+		}
+	}
+
+	if !cfg.IsCI {
+		return nil
+	}
+	for _, res := range results {
+		if res.Status != StatusSkipped {
+			cfg.mask(res.Value)
+		}
+	}
+	if err := batch.commit(files); err != nil {
+		pterm.Error.Printfln("unable to export env variables: %v", err)
+		return fmt.Errorf("cannot set environment variables: %w", err)
+	}
+	for _, res := range results {
+		if res.Status == StatusSkipped {
+			continue
+		}
+		pterm.Success.Printfln("%q: Set env var %q to value in %q", res.Item, strings.ToUpper(res.Item.OutputVariable), res.Item.SecretKey)
+	}
+	return nil
+}
+
+// tokenError classifies a failure to get an access token, which is an authentication failure unless DSV was unreachable or rate limited.
+func tokenError(err error) *Error {
+	kind := classify(err, KindAuthentication)
+	if kind != KindRateLimited && kind != KindNetwork {
+		kind = KindAuthentication
+	}
+	return &Error{Kind: kind, Hint: hint(kind, err, ""), Err: fmt.Errorf("unable to get access token: %w", err)}
+}
+
+// Get retrieves the key of one secret and writes its value to w, with the same allowed paths, policy, references and retries as a run.
+// In GitHub Actions the value is masked first, so it's hidden in the log if w is.
+func (r *Runner) Get(w io.Writer, path, key string) error {
+	cfg := r.Config
+	cfg.fs = r.fileSystem()
+	cfg.commands = r.commands()
+	httpClient := r.httpClient()
+	cfg.mask(cfg.ClientIDEnv, cfg.ClientSecretEnv)
+
+	item := SecretToRetrieve{SecretPath: path, SecretKey: key}
+	apiEndpoint, err := cfg.apiEndpoint()
+	if err != nil {
+		return err
+	}
+	if err := applyPolicy(httpClient, &cfg, []SecretToRetrieve{item}); err != nil {
+		return newError(fmt.Errorf("refused by policy: %w", err), KindConfig, "")
+	}
+	token, err := DSVGetToken(httpClient, apiEndpoint, &cfg)
+	if err != nil {
+		return tokenError(err)
+	}
+	res := resolveItem(httpClient, apiEndpoint, token, item, &cfg)
+	if res.Err != nil {
+		return newError(res.Err, KindUnknown, path)
+	}
+	cfg.mask(res.Value)
+	_, err = fmt.Fprintln(w, res.Value)
+	return err
+}
+
+// Validate checks the inputs and the retrieve configuration the same way a run does, without requesting anything from DSV.
+func (r *Runner) Validate() error {
+	cfg := r.Config
+	cfg.fs = r.fileSystem()
+	if err := validateOnMissing(cfg.OnMissingEnv); err != nil {
+		return &Error{Kind: KindConfig, Err: err}
+	}
+	// The domain is only checked when it's set, so the retrieve configuration can be checked without any credentials.
+	if cfg.DomainEnv != "" || cfg.BaseURLEnv != "" {
+		if _, err := cfg.apiEndpoint(); err != nil {
+			return err
+		}
+	}
+	retrieve, err := loadRetrieve(&cfg)
+	if err != nil {
+		return &Error{Kind: KindConfig, Err: err}
+	}
+	items, err := ParseRetrieve(retrieve)
+	if err != nil {
+		return &Error{Kind: KindConfig, Hint: "retrieve must be a json list of items", Err: err}
+	}
+	resolved, err := resolvePlaceholders(items, r.lookupEnv())
+	if err != nil {
+		return &Error{Kind: KindConfig, Err: fmt.Errorf("unable to resolve placeholders: %w", err)}
+	}
+	if err := validateItems(resolved); err != nil {
+		return &Error{Kind: KindConfig, Err: fmt.Errorf("invalid retrieve configuration: %w", err)}
+	}
+	if patterns := parsePathList(cfg.AllowedPathsEnv); len(patterns) > 0 {
+		if err := errors.Join(validatePathPatterns("DSV_ALLOWED_PATHS", patterns)...); err != nil {
+			return &Error{Kind: KindConfig, Err: err}
+		}
+		if err := new(pathGuard).restrict("DSV_ALLOWED_PATHS", patterns).checkItems(resolved); err != nil {
+			return newError(err, KindConfig, "")
+		}
+	}
+	pterm.Success.Printfln("%d items are valid", len(items))
+	return nil
+}
+
+// finishCheck reports the results of a dry run, which checked every item could be read without keeping or exporting any value.
+// It fails the same way a run would, so a retrieve configuration can be checked before it's rolled out.
+func finishCheck(cfg *Config, files commandFiles, items []SecretToRetrieve, results []itemResult) error {
+	if hasCompose(items) {
+		results = checkCompose(items, results, cfg.OnMissingEnv)
+	}
+	printCheckReport(results)
+	if err := validateOutputVariables(exportedItems(results)); err != nil {
+		pterm.Error.Printfln("conflicting output variables: %v", err)
+		return &Error{Kind: KindConfig, Err: fmt.Errorf("conflicting output variables: %w", err)}
+	}
+	if cfg.IsCI {
+		appendStepSummary(files, checkSummary(results))
+	}
+	if errs := failures(results); len(errs) > 0 {
+		pterm.Error.Printfln("%d of %d items failed the access check", len(errs), len(results))
+		return fmt.Errorf("%d of %d items failed the access check: %w", len(errs), len(results), errors.Join(errs...))
+	}
+	pterm.Success.Printfln("%d items passed the access check, this was a dry run so nothing has been exported", len(results))
+	return nil
+}
@@ -0,0 +1,226 @@
+package dga
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DelineaXPM/dsv-github-action/dga/dsvtest"
+	"github.com/matryer/is"
+	"github.com/pterm/pterm"
+)
+
+func TestRunner(t *testing.T) {
+	fake := dsvtest.New("client-id", "client-secret")
+	fake.AddSecret("ci:db", map[string]any{"password": "hunter2", "user": "app"})
+	fake.AddSecret("ci:api", map[string]any{"token": "line1\nline2"})
+	fake.AddSecret("ci:private:key", map[string]any{"key": "private"})
+	fake.AddSecret("ci:app:db", map[string]any{"password": "app-password", "user": "app"})
+	fake.Forbid("ci:private")
+	server := fake.Start()
+	defer server.Close()
+
+	cases := []struct {
+		name        string
+		cfg         Config
+		secret      string // secret replaces the client secret when set.
+		retrieve    string // retrieve is written to DSV_RETRIEVE_FILE when set.
+		wantCode    int
+		wantEnv     string
+		wantMasks   []string
+		wantSummary []string // wantSummary are lines the step summary should have.
+	}{
+		{
+			name: "exports every item",
+			cfg: Config{
+				IsCI:        true,
+				RetrieveEnv: `[{"secretPath":"ci:db","secretKey":"password","outputVariable":"db_password"},{"secretPath":"ci:db","secretKey":"user","outputVariable":"DB_USER"}]`,
+			},
+			wantEnv:   "DB_PASSWORD=hunter2\nDB_USER=app\n",
+			wantMasks: []string{"::add-mask::hunter2", "::add-mask::app"},
+		},
+		{
+			name: "empty default isn't masked",
+			cfg: Config{
+				IsCI:        true,
+				RetrieveEnv: `[{"secretPath":"ci:db","secretKey":"password","outputVariable":"PASSWORD"},{"secretPath":"ci:nope","secretKey":"key","outputVariable":"NOPE","default":""}]`,
+			},
+			wantEnv:   "PASSWORD=hunter2\nNOPE=\n",
+			wantMasks: []string{"::add-mask::hunter2"},
+		},
+		{
+			name:      "reads the retrieve file and resolves placeholders",
+			cfg:       Config{IsCI: true},
+			retrieve:  `[{"secretPath":"ci:${SERVICE}","secretKey":"password","outputVariable":"PASSWORD"}]`,
+			wantEnv:   "PASSWORD=hunter2\n",
+			wantMasks: []string{"::add-mask::hunter2"},
+		},
+		{
+			name:      "multiline value",
+			cfg:       Config{IsCI: true, RetrieveEnv: `[{"secretPath":"ci:api","secretKey":"token","outputVariable":"TOKEN"}]`},
+			wantEnv:   "TOKEN<<ghadelimiter_",
+			wantMasks: []string{"::add-mask::line1%0Aline2"},
+		},
+		{
+			name:      "prefix exports every secret under it",
+			cfg:       Config{IsCI: true, RetrieveEnv: `[{"type":"prefix","secretPath":"ci:app"}]`},
+			wantEnv:   "DB_PASSWORD=app-password\nDB_USER=app\n",
+			wantMasks: []string{"::add-mask::app-password", "::add-mask::app"},
+		},
+		{
+			name: "missing secret exports nothing",
+			cfg: Config{
+				IsCI:        true,
+				RetrieveEnv: `[{"secretPath":"ci:db","secretKey":"password","outputVariable":"PASSWORD"},{"secretPath":"ci:nope","secretKey":"key","outputVariable":"NOPE"}]`,
+			},
+			wantCode: KindNotFound.ExitCode(),
+		},
+		{
+			name: "missing secret is skipped",
+			cfg: Config{
+				IsCI:         true,
+				OnMissingEnv: OnMissingSkip,
+				RetrieveEnv:  `[{"secretPath":"ci:db","secretKey":"password","outputVariable":"PASSWORD"},{"secretPath":"ci:nope","secretKey":"key","outputVariable":"NOPE"}]`,
+			},
+			wantEnv:   "PASSWORD=hunter2\n",
+			wantMasks: []string{"::add-mask::hunter2"},
+		},
+		{
+			name:     "missing key exports nothing",
+			cfg:      Config{IsCI: true, RetrieveEnv: `[{"secretPath":"ci:db","secretKey":"nope","outputVariable":"NOPE"}]`},
+			wantCode: KindKeyMissing.ExitCode(),
+		},
+		{
+			name:     "invalid credentials",
+			cfg:      Config{IsCI: true, RetrieveEnv: `[{"secretPath":"ci:db","secretKey":"password","outputVariable":"PASSWORD"}]`},
+			secret:   "wrong",
+			wantCode: KindAuthentication.ExitCode(),
+			wantSummary: []string{
+				":x: **Nothing was retrieved:** authentication failed. Hint: check clientId and clientSecret are for a client of the domain, and the client hasn't been deleted.",
+			},
+		},
+		{
+			name:        "refused by the allowed paths",
+			cfg:         Config{IsCI: true, AllowedPathsEnv: "ci:app:*", RetrieveEnv: `[{"secretPath":"ci:db","secretKey":"password","outputVariable":"PASSWORD"}]`},
+			wantCode:    KindForbidden.ExitCode(),
+			wantSummary: []string{":x: **Nothing was retrieved:** refused by policy. Hint: the action's policy refused the path, check allowedPaths and policyFile."},
+		},
+		{
+			name:     "forbidden secret",
+			cfg:      Config{IsCI: true, RetrieveEnv: `[{"secretPath":"ci:private:key","secretKey":"key","outputVariable":"KEY"}]`},
+			wantCode: KindForbidden.ExitCode(),
+		},
+		{
+			name:     "invalid retrieve",
+			cfg:      Config{IsCI: true, RetrieveEnv: `{"secretPath":"ci:db"}`},
+			wantCode: KindConfig.ExitCode(),
+		},
+		{
+			name: "outside of CI neither masks nor writes",
+			cfg:  Config{RetrieveEnv: `[{"secretPath":"ci:db","secretKey":"password","outputVariable":"PASSWORD"}]`},
+		},
+		{
+			name: "dry run checks every item without exporting",
+			cfg: Config{
+				IsCI:        true,
+				DryRun:      true,
+				RetrieveEnv: `[{"secretPath":"ci:db","secretKey":"password","outputVariable":"PASSWORD"},{"secretPath":"ci:nope","secretKey":"key","outputVariable":"NOPE","required":false},{"type":"compose","outputVariable":"DSN","template":"{{ ref \"PASSWORD\" }}@db"}]`,
+			},
+			wantSummary: []string{
+				":white_check_mark: 3 items passed. This was a dry run, nothing was exported.",
+				"| `ci:db` | `password` | `PASSWORD` | :white_check_mark: pass |  |",
+				"| `ci:nope` | `key` | `NOPE` | :white_check_mark: pass | missing, skipped |",
+				"|  |  | `DSN` | :white_check_mark: pass | template not rendered |",
+			},
+		},
+		{
+			name: "dry run reports the items that fail",
+			cfg: Config{
+				IsCI:        true,
+				DryRun:      true,
+				RetrieveEnv: `[{"secretPath":"ci:db","secretKey":"password","outputVariable":"PASSWORD"},{"secretPath":"ci:db","secretKey":"nope","outputVariable":"NOPE"}]`,
+			},
+			wantCode: KindKeyMissing.ExitCode(),
+			wantSummary: []string{
+				":x: **1 of 2 items failed.** This was a dry run, nothing was exported.",
+				"| `ci:db` | `nope` | `NOPE` | :x: fail | missing |",
+			},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			pterm.DisableOutput()
+			is := is.New(t)
+			fsys := NewMemFS()
+			const envFile, summaryFile = "/runner/env", "/runner/summary"
+			fsys.WriteFile(envFile, nil, PermissionReadWriteOwner)
+			fsys.WriteFile(summaryFile, nil, PermissionReadWriteOwner)
+
+			cfg := tc.cfg
+			cfg.DomainEnv = server.Listener.Addr().String()
+			cfg.AllowCustomDomain = true
+			cfg.ClientIDEnv = "client-id"
+			cfg.ClientSecretEnv = "client-secret"
+			if tc.secret != "" {
+				cfg.ClientSecretEnv = tc.secret
+			}
+			if cfg.OnMissingEnv == "" {
+				cfg.OnMissingEnv = OnMissingFail
+			}
+			if tc.retrieve != "" {
+				cfg.RetrieveFileEnv = "/workspace/retrieve.json"
+				fsys.WriteFile(cfg.RetrieveFileEnv, []byte(tc.retrieve), PermissionReadWriteOwner)
+			}
+			environ := map[string]string{EnvFileVariable: envFile, StepSummaryVariable: summaryFile, "SERVICE": "db"}
+			var out bytes.Buffer
+
+			r := &Runner{
+				Config: cfg,
+				HTTP:   server.Client(),
+				FS:     fsys,
+				Now:    func() time.Time { return time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC) },
+				Output: &out,
+				LookupEnv: func(key string) (string, bool) {
+					val, ok := environ[key]
+					return val, ok
+				},
+			}
+			printer := pterm.Error
+			err := r.Run()
+			is.Equal(ExitCode(err), tc.wantCode)         // Run should exit with the expected code.
+			is.Equal(pterm.Error.Writer, printer.Writer) // Run should restore the printers it writes commands with, as they're global.
+
+			got, err := fsys.ReadFile(envFile)
+			is.NoErr(err)                                                                          // Should read env file.
+			is.True(strings.HasPrefix(string(got), tc.wantEnv))                                    // Env file should have the exported values.
+			is.Equal(len(got) == 0, tc.wantEnv == "")                                              // Nothing should be exported unless every item succeeds.
+			is.Equal(strings.Contains(out.String(), "::add-mask::"+cfg.ClientSecretEnv), cfg.IsCI) // Client secret should be masked in GitHub Actions.
+			for _, mask := range tc.wantMasks {
+				is.True(strings.Contains(out.String(), mask)) // Every exported value should be masked.
+			}
+			is.True(!strings.Contains(out.String(), "::add-mask::\n")) // An empty value can't be masked, and the runner warns about it.
+
+			summary, err := fsys.ReadFile(summaryFile)
+			is.NoErr(err)                                          // Should read summary file.
+			is.True(!strings.Contains(string(summary), "hunter2")) // Values should never be in the summary.
+			for _, line := range tc.wantSummary {
+				is.True(strings.Contains(string(summary), line+"\n")) // Summary should report each item.
+			}
+			if tc.cfg.DryRun {
+				is.True(!strings.Contains(out.String(), "hunter2")) // A dry run has nothing to mask, as values aren't kept.
+			}
+			if !tc.cfg.IsCI {
+				is.True(!strings.Contains(out.String(), "hunter2"))       // Outside of CI a mask would only print the value.
+				is.True(!strings.Contains(out.String(), "client-secret")) // The credentials shouldn't be printed either.
+				is.True(!strings.Contains(out.String(), "::add-mask::"))  // Outside of CI nothing reads a mask.
+				is.True(!strings.Contains(out.String(), "::group::"))     // Outside of CI groups would only clutter the log.
+			}
+		})
+	}
+
+	is := is.New(t)
+	for _, req := range fake.Requests() {
+		is.Equal(req.Header.Get("User-Agent"), "dsv-github-action") // Every request should identify the action.
+	}
+}
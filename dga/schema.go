@@ -0,0 +1,16 @@
+package dga
+
+import (
+	"bytes"
+	_ "embed"
+)
+
+// retrieveSchema is the JSON schema of the retrieve input.
+//
+//go:embed schema.json
+var retrieveSchema []byte
+
+// RetrieveSchema returns the JSON schema of the retrieve input, so editors can check a retrieve file as it's written.
+func RetrieveSchema() []byte {
+	return bytes.Clone(retrieveSchema)
+}
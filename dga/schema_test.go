@@ -0,0 +1,46 @@
+package dga
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+// schemaObject is the part of a JSON schema the test reads.
+type schemaObject struct {
+	Type       any                     `json:"type"`
+	Properties map[string]schemaObject `json:"properties"`
+	Items      *schemaObject           `json:"items"`
+}
+
+func TestRetrieveSchema(t *testing.T) {
+	is := is.New(t)
+	var schema schemaObject
+	is.NoErr(json.Unmarshal(RetrieveSchema(), &schema)) // Schema should be valid JSON.
+	is.Equal(schema.Type, "array")                      // Retrieve is a list of items.
+
+	item := schema.Items.Properties
+	cases := []struct {
+		name       string
+		typ        reflect.Type
+		properties map[string]schemaObject
+	}{
+		{name: "item", typ: reflect.TypeOf(SecretToRetrieve{}), properties: item},
+		{name: "fallback", typ: reflect.TypeOf(SecretCandidate{}), properties: item["fallback"].Items.Properties},
+		{name: "validate", typ: reflect.TypeOf(ValueRules{}), properties: item["validate"].Properties},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			is := is.New(t)
+			is.Equal(len(tc.properties), tc.typ.NumField()) // Schema should have a property for each field, and no others.
+			for i := 0; i < tc.typ.NumField(); i++ {
+				name, _, _ := strings.Cut(tc.typ.Field(i).Tag.Get("json"), ",")
+				_, ok := tc.properties[name]
+				is.True(ok) // Each field should be in the schema.
+			}
+		})
+	}
+}
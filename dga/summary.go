@@ -0,0 +1,122 @@
+package dga
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/pterm/pterm"
+)
+
+// StepSummaryVariable is the environment variable GitHub uses to point at the Markdown file shown on the job summary page.
+const StepSummaryVariable = "GITHUB_STEP_SUMMARY"
+
+// appendStepSummary appends Markdown to the job summary.
+// The summary is a convenience, so failing to write it is logged rather than failing the run.
+func appendStepSummary(files commandFiles, markdown string) {
+	f, err := files.open(StepSummaryVariable)
+	if err != nil {
+		pterm.Warning.Printfln("unable to open step summary: %v", err)
+		return
+	}
+	defer f.Close()
+	if _, err := io.WriteString(f, markdown); err != nil {
+		pterm.Warning.Printfln("unable to write step summary: %v", err)
+	}
+}
+
+// resolvedPathsSummary is a Markdown table of each path as configured and after placeholders were resolved.
+func resolvedPathsSummary(configured, resolved []SecretToRetrieve) string {
+	var sb strings.Builder
+	sb.WriteString("### DSV Secret Paths\n\n")
+	sb.WriteString("| Configured | Resolved |\n")
+	sb.WriteString("| --- | --- |\n")
+	for i := range configured {
+		sb.WriteString(fmt.Sprintf("| %s | %s |\n", markdownCode(configured[i].SecretPath), markdownCode(resolved[i].SecretPath)))
+	}
+	sb.WriteString("\n")
+	return sb.String()
+}
+
+// markdownCode formats s as inline code that is safe inside a table cell.
+func markdownCode(s string) string {
+	if s == "" {
+		return ""
+	}
+	s = strings.NewReplacer("`", "'", "|", "\\|", "\n", " ", "\r", " ").Replace(s)
+	return "`" + s + "`"
+}
+
+// failureSummary is the summary of a run that failed before any item was retrieved, such as when it's refused by policy or can't authenticate.
+// Only the reason and the hint are included, so the summary is safe to share the same as the results.
+func failureSummary(reason string, err error) string {
+	var sb strings.Builder
+	sb.WriteString("### DSV Secrets\n\n")
+	sb.WriteString(fmt.Sprintf(":x: **Nothing was retrieved:** %s.", reason))
+	var e *Error
+	if errors.As(err, &e) && e.Hint != "" {
+		sb.WriteString(" Hint: " + e.Hint + ".")
+	}
+	sb.WriteString("\n\n")
+	return sb.String()
+}
+
+// resultsSummary is a Markdown table of every item's outcome, with a line naming the items that failed.
+// Values are never included, and errors are reduced to a reason, so the summary is safe to share.
+func resultsSummary(results []itemResult, exportMetadata bool) string {
+	var sb strings.Builder
+	sb.WriteString("### DSV Secrets\n\n")
+
+	var failed []string
+	for _, res := range results {
+		if res.Status == StatusFailed {
+			failed = append(failed, fmt.Sprintf("%s (%s)", markdownCode(res.Item.String()), failureReason(res.Err)))
+		}
+	}
+	if len(failed) > 0 {
+		sb.WriteString(fmt.Sprintf(":x: **%d of %d items failed, nothing was exported:** %s\n\n", len(failed), len(results), strings.Join(failed, ", ")))
+	} else {
+		sb.WriteString(fmt.Sprintf(":white_check_mark: %d items resolved\n\n", len(results)))
+	}
+
+	sb.WriteString("| Path | Key | Output | Version | Status | Latency | Warnings |\n")
+	sb.WriteString("| --- | --- | --- | --- | --- | --- | --- |\n")
+	for _, res := range results {
+		output := ""
+		if res.Item.OutputVariable != "" {
+			output = "env " + markdownCode(strings.ToUpper(res.Item.OutputVariable))
+			if exportMetadata && res.Metadata != nil {
+				output += " and metadata outputs"
+			}
+		}
+		version := ""
+		if res.Metadata != nil {
+			version = markdownCode(res.Metadata.Version)
+		}
+		status := string(res.Status)
+		if res.Status == StatusFailed {
+			status += ": " + failureReason(res.Err)
+		}
+		latency := ""
+		if res.Latency > 0 {
+			latency = res.Latency.Round(time.Millisecond).String()
+		}
+		warnings := resultWarnings(res)
+		for i := range warnings {
+			warnings[i] = strings.NewReplacer("|", "\\|", "\n", " ").Replace(warnings[i])
+		}
+		sb.WriteString(fmt.Sprintf("| %s | %s | %s | %s | %s | %s | %s |\n",
+			markdownCode(res.Item.SecretPath),
+			markdownCode(res.Item.SecretKey),
+			output,
+			version,
+			status,
+			latency,
+			strings.Join(warnings, "; "),
+		))
+	}
+	sb.WriteString("\n")
+	return sb.String()
+}
@@ -0,0 +1,60 @@
+package dga
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+func TestResultsSummary(t *testing.T) {
+	is := is.New(t)
+	results := []itemResult{
+		{
+			Item:     SecretToRetrieve{SecretPath: "ci:db", SecretKey: "password", OutputVariable: "db_password"},
+			Status:   StatusRetrieved,
+			Value:    "hunter2",
+			Metadata: &SecretMetadata{Version: "3"},
+			Used:     SecretCandidate{SecretPath: "ci:db:legacy", SecretKey: "password"},
+			Latency:  120 * time.Millisecond,
+		},
+		{
+			Item:   SecretToRetrieve{SecretPath: "ci:api", SecretKey: "token", OutputVariable: "API_TOKEN"},
+			Status: StatusFailed,
+			Err:    fmt.Errorf("%q: unable to get secret: %w", "ci:api", &StatusError{Method: http.MethodGet, StatusCode: http.StatusForbidden}),
+		},
+		{
+			Item:   SecretToRetrieve{SecretPath: "ci:optional", SecretKey: "key", OutputVariable: "OPTIONAL"},
+			Status: StatusSkipped,
+		},
+	}
+	got := resultsSummary(results, true)
+
+	is.True(!strings.Contains(got, "hunter2"))                                                                                                                          // Values should never be included.
+	is.True(strings.Contains(got, "**1 of 3 items failed, nothing was exported:** `ci:api#token` (forbidden)"))                                                         // Failures should be listed at a glance.
+	is.True(strings.Contains(got, "| `ci:db` | `password` | env `DB_PASSWORD` and metadata outputs | `3` | retrieved | 120ms | used fallback ci:db:legacy#password |")) // Retrieved row should be complete.
+	is.True(strings.Contains(got, "| failed: forbidden |"))                                                                                                             // Failed row should give the reason.
+	is.True(strings.Contains(got, "| skipped |  | missing, skipped |"))                                                                                                 // Skipped row should warn.
+}
+
+func TestFailureReason(t *testing.T) {
+	cases := []struct {
+		err  error
+		want string
+	}{
+		{err: fmt.Errorf("x: %w", ErrNotFound), want: "missing"},
+		{err: fmt.Errorf("x: %w", ErrNotAllowed), want: "denied by policy"},
+		{err: fmt.Errorf("x: %w: bad", ErrInvalidValue), want: "invalid value"},
+		{err: &StatusError{StatusCode: http.StatusUnauthorized}, want: "forbidden"},
+		{err: &StatusError{StatusCode: http.StatusInternalServerError}, want: "error"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.want, func(t *testing.T) {
+			is := is.New(t)
+			is.Equal(failureReason(tc.err), tc.want) // Reason should match the error.
+		})
+	}
+}
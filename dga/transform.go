@@ -0,0 +1,166 @@
+package dga
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Transform converts a value after it's retrieved, and before it's exported and masked.
+// Implementations are added with RegisterTransform and used by name in an item's "transforms" list.
+type Transform interface {
+	// Name is how the transform is referenced in the retrieve configuration.
+	Name() string
+	// Apply returns the converted value. Errors must not include the value, as they are logged.
+	Apply(value string) (string, error)
+}
+
+//nolint:gochecknoglobals // registry of transforms, which forks can add to with RegisterTransform.
+var (
+	transformsMu sync.RWMutex
+	transforms   = make(map[string]Transform)
+)
+
+// RegisterTransform makes a transform available by its name.
+// It's intended to be called from init, and panics if the transform is nil or the name is already registered, the same as database/sql.Register.
+func RegisterTransform(t Transform) {
+	transformsMu.Lock()
+	defer transformsMu.Unlock()
+	if t == nil {
+		panic("dga: RegisterTransform transform is nil")
+	}
+	if _, dup := transforms[t.Name()]; dup {
+		panic("dga: RegisterTransform called twice for transform " + t.Name())
+	}
+	transforms[t.Name()] = t
+}
+
+// Transforms returns the names of every registered transform, sorted.
+func Transforms() []string {
+	transformsMu.RLock()
+	defer transformsMu.RUnlock()
+	names := make([]string, 0, len(transforms))
+	for name := range transforms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func lookupTransform(name string) (Transform, bool) {
+	transformsMu.RLock()
+	defer transformsMu.RUnlock()
+	t, ok := transforms[name]
+	return t, ok
+}
+
+// validateTransforms checks every transform an item uses is registered.
+func validateTransforms(items []SecretToRetrieve) []error {
+	var errs []error
+	for _, item := range items {
+		for _, name := range item.Transforms {
+			if _, ok := lookupTransform(name); !ok {
+				errs = append(errs, fmt.Errorf("%q: transform %q is not registered, use one of %s", item, name, strings.Join(Transforms(), ", ")))
+			}
+		}
+	}
+	return errs
+}
+
+// applyTransforms runs each named transform over the value in order.
+func applyTransforms(names []string, value string) (string, error) {
+	for _, name := range names {
+		t, ok := lookupTransform(name)
+		if !ok {
+			return "", fmt.Errorf("transform %q is not registered", name)
+		}
+		var err error
+		if value, err = t.Apply(value); err != nil {
+			return "", fmt.Errorf("transform %q failed: %w", name, err)
+		}
+	}
+	return value, nil
+}
+
+// structuredAsJSON encodes an object or array value as JSON, so it can be given to a transform such as dotenv.
+// References are left alone for followRefs to handle.
+func structuredAsJSON(val any) any {
+	switch val.(type) {
+	case map[string]any, []any:
+		if _, isRef := parseRef(val); isRef {
+			return val
+		}
+		b, err := json.Marshal(val)
+		if err != nil {
+			return val
+		}
+		return string(b)
+	default:
+		return val
+	}
+}
+
+func init() {
+	RegisterTransform(transformFunc{"trim", func(s string) (string, error) {
+		return strings.TrimSpace(s), nil
+	}})
+	RegisterTransform(transformFunc{"base64decode", func(s string) (string, error) {
+		b, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+		if err != nil {
+			return "", fmt.Errorf("value is not valid base64")
+		}
+		return string(b), nil
+	}})
+	RegisterTransform(transformFunc{"hexdecode", func(s string) (string, error) {
+		b, err := hex.DecodeString(strings.TrimSpace(s))
+		if err != nil {
+			return "", fmt.Errorf("value is not valid hex")
+		}
+		return string(b), nil
+	}})
+	RegisterTransform(transformFunc{"dotenv", dotenv})
+	RegisterTransform(transformFunc{"lf", func(s string) (string, error) {
+		return strings.ReplaceAll(strings.ReplaceAll(s, "\r\n", "\n"), "\r", "\n"), nil
+	}})
+}
+
+// transformFunc adapts a function to the Transform interface.
+type transformFunc struct {
+	name string
+	fn   func(string) (string, error)
+}
+
+func (t transformFunc) Name() string                       { return t.name }
+func (t transformFunc) Apply(value string) (string, error) { return t.fn(value) }
+
+// dotenv converts a JSON object to KEY=value lines, sorted by key.
+// Values that aren't plain words are double quoted, with backslashes, quotes and newlines escaped.
+func dotenv(s string) (string, error) {
+	var obj map[string]any
+	if err := json.Unmarshal([]byte(s), &obj); err != nil {
+		return "", fmt.Errorf("value is not a JSON object")
+	}
+	lines := make([]string, 0, len(obj))
+	for _, key := range sortedKeys(obj) {
+		var val string
+		switch v := obj[key].(type) {
+		case string:
+			val = v
+		default:
+			b, err := json.Marshal(v)
+			if err != nil {
+				return "", fmt.Errorf("key %q can't be encoded", key)
+			}
+			val = string(b)
+		}
+		if strings.ContainsAny(val, " \t\r\n\"'\\#$`=") {
+			val = `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`, "$", `\$`, "`", "\\`").Replace(val) + `"`
+		}
+		lines = append(lines, envName(key)+"="+val)
+	}
+	return strings.Join(lines, "\n"), nil
+}
@@ -0,0 +1,88 @@
+package dga
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/matryer/is"
+	"github.com/pterm/pterm"
+)
+
+// reverse is a transform registered by the tests, the same way a fork would add its own.
+type reverse struct{}
+
+func (reverse) Name() string { return "test-reverse" }
+
+func (reverse) Apply(value string) (string, error) {
+	r := []rune(value)
+	for i, j := 0, len(r)-1; i < j; i, j = i+1, j-1 {
+		r[i], r[j] = r[j], r[i]
+	}
+	return string(r), nil
+}
+
+func init() {
+	RegisterTransform(reverse{})
+}
+
+func TestApplyTransforms(t *testing.T) {
+	cases := []struct {
+		name       string
+		transforms []string
+		value      string
+		want       string
+		wantErr    bool
+	}{
+		{name: "none", value: " as is ", want: " as is "},
+		{name: "trim", transforms: []string{"trim"}, value: "  token\n", want: "token"},
+		{name: "base64", transforms: []string{"base64decode"}, value: "aHVudGVyMg==\n", want: "hunter2"},
+		{name: "hex", transforms: []string{"hexdecode"}, value: "68756e74657232", want: "hunter2"},
+		{name: "in order", transforms: []string{"trim", "base64decode", "test-reverse"}, value: " aHVudGVyMg== ", want: "2retnuh"},
+		{
+			name:       "dotenv",
+			transforms: []string{"dotenv"},
+			value:      `{"db_user":"app","db_pass":"p w\"d","port":5432}`,
+			want:       "DB_PASS=\"p w\\\"d\"\nDB_USER=app\nPORT=5432",
+		},
+		{name: "lf", transforms: []string{"lf"}, value: "line1\r\nline2\rline3\n", want: "line1\nline2\nline3\n"},
+		{name: "invalid base64", transforms: []string{"base64decode"}, value: "not base64!", wantErr: true},
+		{name: "dotenv of a string", transforms: []string{"dotenv"}, value: "plain", wantErr: true},
+		{name: "unknown", transforms: []string{"rot13"}, value: "x", wantErr: true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			is := is.New(t)
+			got, err := applyTransforms(tc.transforms, tc.value)
+			if tc.wantErr {
+				is.True(err != nil)                               // Should fail.
+				is.True(!strings.Contains(err.Error(), tc.value)) // Error should not include the value.
+				return
+			}
+			is.NoErr(err)          // Should transform.
+			is.Equal(got, tc.want) // Transformed value should match.
+		})
+	}
+}
+
+func TestRegisterTransformDuplicatePanics(t *testing.T) {
+	is := is.New(t)
+	defer func() {
+		is.True(recover() != nil) // Registering the same name twice should panic.
+	}()
+	RegisterTransform(reverse{})
+}
+
+func TestResolveItemTransforms(t *testing.T) {
+	pterm.DisableOutput()
+	is := is.New(t)
+	server := routes{
+		"/v1/secrets/app:config": {status: http.StatusOK, body: `{"data":{"env":{"b":"2","a":"1"}}}`},
+	}
+	item := SecretToRetrieve{SecretPath: "app:config", SecretKey: "env", OutputVariable: "APP_ENV", Transforms: []string{"dotenv"}}
+	res := resolveItem(server, "https://example.com/v1", "token", item, &Config{})
+	is.NoErr(res.Err)               // Object value should be accepted when transforms are set.
+	is.Equal(res.Value, "A=1\nB=2") // Object should be converted to dotenv lines.
+
+	is.Equal(len(validateTransforms([]SecretToRetrieve{{Transforms: []string{"trim", "nope"}}})), 1) // Unknown transforms should be reported before running.
+}
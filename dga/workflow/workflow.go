@@ -0,0 +1,158 @@
+// Package workflow writes GitHub Actions workflow commands, such as annotations, log groups and masks.
+// Messages and properties are escaped as GitHub specifies, so a message containing a newline is kept in one command rather than breaking it.
+// https://docs.github.com/en/actions/using-workflows/workflow-commands-for-github-actions
+package workflow
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ansiEscape matches the color codes a terminal logger may add, which have no meaning in an annotation.
+var ansiEscape = regexp.MustCompile("\x1b\\[[0-9;]*m")
+
+// Annotation is where a warning, notice or error points, and the title shown for it.
+// Every field is optional.
+type Annotation struct {
+	Title     string
+	File      string
+	Line      int
+	EndLine   int
+	Col       int
+	EndColumn int
+}
+
+// properties returns the annotation as command properties, in the order GitHub documents them.
+func (a Annotation) properties() [][2]string {
+	var props [][2]string
+	add := func(key, val string) {
+		if val != "" {
+			props = append(props, [2]string{key, val})
+		}
+	}
+	addInt := func(key string, val int) {
+		if val > 0 {
+			add(key, strconv.Itoa(val))
+		}
+	}
+	add("title", a.Title)
+	add("file", a.File)
+	addInt("line", a.Line)
+	addInt("endLine", a.EndLine)
+	addInt("col", a.Col)
+	addInt("endColumn", a.EndColumn)
+	return props
+}
+
+// EscapeData escapes a command message, the same as @actions/core.
+func EscapeData(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A").Replace(s)
+}
+
+// EscapeProperty escapes a command property value, which also can't contain the : and , that separate properties.
+func EscapeProperty(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A", ":", "%3A", ",", "%2C").Replace(s)
+}
+
+// Command formats a workflow command, such as "::warning title=Deprecated::message".
+func Command(name string, props [][2]string, message string) string {
+	var sb strings.Builder
+	sb.WriteString("::")
+	sb.WriteString(name)
+	for i, prop := range props {
+		if i == 0 {
+			sb.WriteString(" ")
+		} else {
+			sb.WriteString(",")
+		}
+		sb.WriteString(prop[0] + "=" + EscapeProperty(prop[1]))
+	}
+	sb.WriteString("::")
+	sb.WriteString(EscapeData(message))
+	return sb.String()
+}
+
+// Writer writes workflow commands, one per line.
+type Writer struct {
+	mu  sync.Mutex
+	out io.Writer
+}
+
+// New returns a Writer for out, which is os.Stdout on a runner.
+func New(out io.Writer) *Writer {
+	return &Writer{out: out}
+}
+
+// issue writes a single command.
+func (w *Writer) issue(name string, props [][2]string, message string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	fmt.Fprintln(w.out, Command(name, props, message))
+}
+
+// Group starts a collapsible group in the log, which lasts until EndGroup.
+func (w *Writer) Group(title string) { w.issue("group", nil, title) }
+
+// EndGroup ends the current group.
+func (w *Writer) EndGroup() { w.issue("endgroup", nil, "") }
+
+// Debug writes a message only shown when debug logging is enabled.
+func (w *Writer) Debug(message string) { w.issue("debug", nil, message) }
+
+// Notice creates a notice annotation.
+func (w *Writer) Notice(a Annotation, message string) { w.issue("notice", a.properties(), message) }
+
+// Warning creates a warning annotation.
+func (w *Writer) Warning(a Annotation, message string) { w.issue("warning", a.properties(), message) }
+
+// Error creates an error annotation.
+func (w *Writer) Error(a Annotation, message string) { w.issue("error", a.properties(), message) }
+
+// AddMask masks the value in every later log line. A value with several lines is escaped, so every line is masked.
+func (w *Writer) AddMask(value string) { w.issue("add-mask", nil, value) }
+
+// CommandWriter returns an io.Writer that turns each write into a command with the name, such as "error".
+// It's intended for a logger that writes one message per call, so the whole message stays in one annotation.
+// Color codes and surrounding whitespace are removed.
+func (w *Writer) CommandWriter(name string) io.Writer {
+	return commandWriter{w: w, name: name}
+}
+
+type commandWriter struct {
+	w    *Writer
+	name string
+}
+
+func (c commandWriter) Write(p []byte) (int, error) {
+	message := strings.TrimSpace(ansiEscape.ReplaceAllString(string(p), ""))
+	if message != "" {
+		c.w.issue(c.name, nil, message)
+	}
+	return len(p), nil
+}
+
+//nolint:gochecknoglobals // the default writer for the runner's stdout, the same as the log package.
+var std = New(os.Stdout)
+
+// Default returns the Writer for os.Stdout.
+func Default() *Writer { return std }
+
+// Group starts a collapsible group in the log of os.Stdout.
+func Group(title string) { std.Group(title) }
+
+// EndGroup ends the current group in the log of os.Stdout.
+func EndGroup() { std.EndGroup() }
+
+// Notice creates a notice annotation on os.Stdout.
+func Notice(a Annotation, message string) { std.Notice(a, message) }
+
+// Warning creates a warning annotation on os.Stdout.
+func Warning(a Annotation, message string) { std.Warning(a, message) }
+
+// AddMask masks the value in every later log line of os.Stdout.
+func AddMask(value string) { std.AddMask(value) }
@@ -0,0 +1,53 @@
+package workflow
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func TestCommand(t *testing.T) {
+	cases := []struct {
+		name    string
+		command string
+		props   [][2]string
+		message string
+		want    string
+	}{
+		{name: "plain", command: "error", message: "failed", want: "::error::failed"},
+		{name: "newline", command: "error", message: "line 1\nline 2\r\n", want: "::error::line 1%0Aline 2%0D%0A"},
+		{name: "percent", command: "notice", message: "100%", want: "::notice::100%25"},
+		{
+			name:    "properties",
+			command: "warning",
+			props:   [][2]string{{"title", "Deprecated: a, b"}, {"line", "3"}},
+			message: "use c",
+			want:    "::warning title=Deprecated%3A a%2C b,line=3::use c",
+		},
+		{name: "end group", command: "endgroup", want: "::endgroup::"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			is := is.New(t)
+			is.Equal(Command(tc.command, tc.props, tc.message), tc.want) // Command should be escaped.
+		})
+	}
+}
+
+func TestWriter(t *testing.T) {
+	is := is.New(t)
+	var out bytes.Buffer
+	w := New(&out)
+	w.Group("Retrieve ci:db#password")
+	w.Warning(Annotation{Title: "Secret missing", Line: 2}, "used the default")
+	w.AddMask("line 1\nline 2")
+	w.EndGroup()
+	fmt.Fprint(w.CommandWriter("error"), "  \x1b[31mfailed\nbecause\x1b[0m \n")
+	is.Equal(out.String(), "::group::Retrieve ci:db#password\n"+
+		"::warning title=Secret missing,line=2::used the default\n"+
+		"::add-mask::line 1%0Aline 2\n"+
+		"::endgroup::\n"+
+		"::error::failed%0Abecause\n") // Commands should be written one per line.
+}
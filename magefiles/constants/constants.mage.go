@@ -17,6 +17,21 @@ const (
 
 	// SecretFile is a local env file for testing integration with github action and not added to source control.
 	SecretFile = ".cache/.secrets"
+
+	// PermissionUserReadWrite is the permissions for files written to the cache directory.
+	PermissionUserReadWrite = 0o0600
+)
+
+// Fake DSV constants, used by test:actIntegration when there's no SecretFile.
+const (
+	// FakePort is the port dsvtest listens on.
+	FakePort = "8443"
+	// FakeAddress is the address dsvtest listens on, reachable from the containers act starts.
+	FakeAddress = "0.0.0.0:" + FakePort
+	// FakeSecretFile is the act secret file pointing the integration workflow at dsvtest.
+	FakeSecretFile = ".cache/.secrets.dsvtest"
+	// FakeCertFile is where dsvtest writes its certificate, for the action to trust.
+	FakeCertFile = ".cache/dsvtest.pem"
 )
 
 // Docker constants.
@@ -2,9 +2,13 @@
 package main
 
 import (
+	"fmt"
+	"net"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/DelineaXPM/dsv-github-action/magefiles/constants"
 
@@ -103,6 +107,7 @@ func Clean() {
 }
 
 // ActIntegration runs local act cli to test the integration.
+// Without the secret file, the action reads from a fake DSV started with dsvtest instead of a real tenant.
 func (Test) ActIntegration() error {
 	binary, err := exec.LookPath("act")
 	if err != nil {
@@ -110,13 +115,122 @@ func (Test) ActIntegration() error {
 		return err
 	}
 
+	if _, err := os.Stat(constants.SecretFile); err == nil {
+		return sh.RunV(
+			binary,
+			"--job", "integration",
+			"--secret-file", constants.SecretFile,
+		)
+	}
+
+	pterm.Warning.Printfln("%s not found, using a fake DSV", constants.SecretFile)
+	stop, err := startFakeDSV()
+	if err != nil {
+		return err
+	}
+	defer stop()
+
 	return sh.RunV(
 		binary,
 		"--job", "integration",
-		"--secret-file", constants.SecretFile,
+		"--secret-file", constants.FakeSecretFile,
+		// The action trusts the fake's self-signed certificate from the workspace, which act mounts at /github/workspace.
+		"--env", "SSL_CERT_FILE=/github/workspace/"+constants.FakeCertFile,
+		"--container-options", "--add-host=host.docker.internal:host-gateway",
 	)
 }
 
+// startFakeDSV builds and starts dsvtest, and writes the act secret file for it.
+// The returned function stops it.
+func startFakeDSV() (func(), error) {
+	mg.Deps(createDirectories)
+	fakeBinary := filepath.Join(constants.ArtifactDirectory, "dsvtest")
+	if err := sh.RunV("go", "build", "-o", fakeBinary, "./dga/dsvtest/cmd/dsvtest"); err != nil {
+		return nil, err
+	}
+	_ = os.Remove(constants.FakeCertFile)
+
+	cmd := exec.Command(fakeBinary, "-addr", constants.FakeAddress, "-cert-file", constants.FakeCertFile)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	stop := func() {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+	}
+
+	// The certificate is written just before the fake starts listening.
+	deadline := time.Now().Add(fakeStartTimeout)
+	for {
+		conn, err := net.Dial("tcp", constants.FakeAddress)
+		if err == nil {
+			conn.Close()
+			break
+		}
+		if time.Now().After(deadline) {
+			stop()
+			return nil, fmt.Errorf("dsvtest didn't start listening on %s: %w", constants.FakeAddress, err)
+		}
+		time.Sleep(fakeStartPoll)
+	}
+
+	// The values match the default seed of dsvtest.
+	secrets := strings.Join([]string{
+		"DSV_SERVER=host.docker.internal:" + constants.FakePort,
+		"DSV_ALLOW_CUSTOM_DOMAIN=true",
+		"DSV_CLIENT_ID=dsvtest-client-id",
+		"DSV_CLIENT_SECRET=dsvtest-client-secret",
+		"DSV_EXPECTED_VALUE_1=dsvtest-value-1",
+		"DSV_EXPECTED_VALUE_2=dsvtest-value-2",
+	}, "\n") + "\n"
+	if err := os.WriteFile(constants.FakeSecretFile, []byte(secrets), constants.PermissionUserReadWrite); err != nil {
+		stop()
+		return nil, err
+	}
+	return stop, nil
+}
+
+const (
+	fakeStartTimeout = 10 * time.Second
+	fakeStartPoll    = 100 * time.Millisecond
+)
+
+// RefreshFixtures records the DSV responses in dga/testdata/fixtures again, from the tenant in the secret file.
+// Tokens, credentials and secret values are replaced with placeholders, and a fixture that still looks like it has a secret isn't saved.
+func (Test) RefreshFixtures() error {
+	env, err := readEnvFile(constants.SecretFile)
+	if err != nil {
+		pterm.Error.Printfln("unable to read %s, which needs DSV_DOMAIN, DSV_CLIENT_ID and DSV_CLIENT_SECRET: %v", constants.SecretFile, err)
+		return err
+	}
+	recordEnv := map[string]string{"DSV_RECORD_FIXTURES": "true"}
+	for _, key := range []string{"DSV_DOMAIN", "DSV_CLIENT_ID", "DSV_CLIENT_SECRET"} {
+		recordEnv[key] = env[key]
+	}
+	return sh.RunWithV(recordEnv, "go", "test", "./dga/", "-run", "^TestFixture", "-count=1")
+}
+
+// readEnvFile reads KEY=VALUE lines, ignoring blank lines and comments.
+func readEnvFile(name string) (map[string]string, error) {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	env := make(map[string]string)
+	for _, line := range strings.Split(string(b), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if key, val, ok := strings.Cut(line, "="); ok {
+			env[key] = val
+		}
+	}
+	return env, nil
+}
+
 // Unit runs go unit tests.
 func (Test) Unit() {
 	mg.Deps(gotools.Go{}.TestSum("./..."))
@@ -4,12 +4,10 @@ import (
 	"os"
 
 	"github.com/DelineaXPM/dsv-github-action/dga"
+	"github.com/DelineaXPM/dsv-github-action/dga/cli"
 	"github.com/pterm/pterm"
 )
 
-// exitFailure is exit code sent for failed task.
-const exitFailure = 1
-
 //nolint:gochecknoglobals // ok for providing as version output
 var (
 	version = "dev"
@@ -18,11 +16,9 @@ var (
 )
 
 func main() {
-	pterm.Info.Printf("version: %s\n"+"commit: %s\n"+"built: %s\n", version, commit, date)
-
-	if err := dga.Run(); err != nil {
+	app := &cli.App{Version: version, Commit: commit, Date: date}
+	if err := app.Run(os.Args[1:]); err != nil {
 		pterm.Error.Printfln("run(): %v", err)
-		os.Exit(exitFailure)
+		os.Exit(dga.ExitCode(err))
 	}
-	pterm.Success.Println("complete with success")
 }